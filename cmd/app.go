@@ -1,16 +1,24 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
-	"github.com/lxc/incus/v6/shared/api"
 	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/history"
+	"github.com/rbnhln/incusAutobackup/internal/lock"
 	"github.com/rbnhln/incusAutobackup/internal/notifications"
 	"github.com/rbnhln/incusAutobackup/internal/runner"
+	"github.com/rbnhln/incusAutobackup/internal/tracing"
 )
 
-func (app *application) serve() (retErr error) {
+// serve executes one backup run. Cancelling ctx aborts the run, running Incus operations are cancelled.
+func (app *application) serve(ctx context.Context) (retErr error) {
 	app.logger.Info("Application started")
 
 	// if configTypeBytes, err := json.MarshalIndent(app.config, "", "  "); err == nil {
@@ -22,148 +30,477 @@ func (app *application) serve() (retErr error) {
 		return err
 	}
 
-	fmt.Printf("Config validated!\n")
+	app.logger.Info("Config validated")
 
-	// for notifications
-	ctx := context.Background()
-	notif := notifications.NewManagerFromConfig(app.logger, app.config)
-	notif.Start(ctx)
+	// the history gets an entry also for runs failing before their tasks, e.g. on connect or lock errors
+	start := time.Now()
+	var report *runner.Report
 	defer func() {
-		ok := retErr == nil
-		_ = notif.Finish(ctx, ok)
+		switch {
+		case report != nil:
+			app.recordHistory(history.RunFromReport(report))
+		case retErr != nil:
+			app.recordHistory(history.FailedRun(start, time.Now(), retErr))
+		}
+	}()
+
+	if endpoint := app.config.IAB.Tracing.Endpoint; endpoint != "" {
+		shutdown, err := tracing.Setup(ctx, endpoint, app.config.IAB.Tracing.Headers, version, app.config.IAB.UUID)
+		if err != nil {
+			return err
+		}
+		// flush the spans of the run, also if it was aborted
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			defer cancel()
+			err := shutdown(ctx)
+			if err != nil {
+				app.logger.Warn("failed to export traces", "endpoint", endpoint, "error", err)
+			}
+		}()
+	}
+
+	// an overlapping run on this machine is rejected before anything is notified
+	fileLock, err := lock.AcquireFile(app.config.IAB.Lock.FilePath())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := fileLock.Release()
+		if err != nil {
+			app.logger.Warn("failed to release lockfile", "error", err)
+		}
+	}()
+
+	// metrics are exported for every run which got the lock, also if it failed early
+	defer func() {
+		// a failed notification does not make the backup unsuccessful
+		success := retErr == nil || errors.Is(retErr, notifications.ErrDelivery)
+		app.exportMetrics(context.WithoutCancel(ctx), report, success)
+	}()
+
+	// notifications are delivered even if the run is aborted
+	notifCtx := context.WithoutCancel(ctx)
+	notif, err := notifications.NewManagerFromConfig(app.logger, app.config)
+	if err != nil {
+		return err
+	}
+	notif.Flush(notifCtx)
+	notif.Start(notifCtx)
+	defer func() {
+		summary := notifications.Summary{
+			Result: notifications.ResultSuccess,
+			Start:  start,
+			End:    time.Now(),
+			Report: report,
+			Err:    retErr,
+		}
+		for _, h := range app.config.Hosts {
+			summary.Hosts = append(summary.Hosts, h.Name)
+		}
+		switch {
+		case errors.Is(retErr, context.Canceled):
+			summary.Result = notifications.ResultAborted
+		case retErr != nil:
+			summary.Result = notifications.ResultFailed
+		}
+		err := notif.Finish(notifCtx, summary)
+		if err != nil && retErr == nil {
+			retErr = err
+		}
 	}()
 
+	sources, projectHops, hosts, err := app.runTopology()
+	if err != nil {
+		return err
+	}
+
+	// establish connection to all sources and targets
+	app.logger.Info("Establishing connection to IncusOS-Server")
+
+	clients, unreachable, err := app.ConnectToHosts(hosts)
+	if err != nil {
+		return err
+	}
+
+	if app.config.IAB.Lock.Server {
+		release, err := app.lockHosts(hosts, clients)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	projects, discovered, err := app.resolveProjects(clients)
+	if err != nil {
+		return err
+	}
+	plan := app.buildPlan(projects, sources, projectHops)
+	plan.Discovered = discovered
+
+	timeouts, retry, err := app.taskPolicies()
+	if err != nil {
+		return err
+	}
+
+	cc := app.config.IAB.Concurrency
+	exec := &runner.ExecCtx{
+		Ctx:           ctx,
+		Logger:        app.logger,
+		Hosts:         clients,
+		Unreachable:   unreachable,
+		DryRunCopy:    app.config.IAB.DryRunCopy,
+		DryRunPrune:   app.config.IAB.DryRunPrune,
+		StopInstances: app.config.IAB.StopInstance,
+		Limits: runner.Limits{
+			Workers: cc.Tasks,
+			PerHost: cc.PerHost,
+			PerPool: cc.PerPool,
+			Hosts:   cc.Hosts,
+			Pools:   cc.Pools,
+		},
+		Timeouts:  timeouts,
+		Retry:     retry,
+		Snapshots: runner.NewSnapshotStore(),
+		OnTask: func(t runner.TaskReport) {
+			notif.TaskFinished(notifCtx, t)
+		},
+		OnPhase: func(p runner.PhaseResult) {
+			notif.Progress(notifCtx, fmt.Sprintf("phase %s finished in %s: %d tasks, %d failed, %d skipped",
+				p.Name, p.Duration.Round(time.Second), p.Tasks, p.Failed, p.Skipped))
+		},
+	}
+	report, err = plan.Execute(exec)
+	app.writeReport(report)
+	return err
+}
+
+// recordHistory appends the outcome of the run to the history file, if configured.
+func (app *application) recordHistory(run history.Run) {
+	path := app.config.IAB.HistoryFile
+	if path == "" {
+		return
+	}
+	err := history.Append(path, run)
+	if err != nil {
+		app.logger.Error("failed to record run history", "path", path, "error", err)
+	}
+}
+
+// writeReport stores the run report in the configured file and prints it if requested.
+// Failures are only logged, the report must not fail the backup run.
+func (app *application) writeReport(report *runner.Report) {
+	if path := app.config.IAB.ReportFile; path != "" {
+		err := report.WriteFile(path)
+		if err != nil {
+			app.logger.Error("failed to write run report", "path", path, "error", err)
+		} else {
+			app.logger.Info("run report written", "path", path)
+		}
+	}
+
+	if app.config.IAB.ReportFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(report)
+		if err != nil {
+			app.logger.Error("failed to print run report", "error", err)
+		}
+	}
+}
+
+// taskPolicies converts the timeout and retry settings for the runner.
+func (app *application) taskPolicies() (map[runner.Kind]time.Duration, runner.RetryPolicy, error) {
+	to := app.config.IAB.Timeouts
+	timeouts := make(map[runner.Kind]time.Duration, 3)
+	for kind, s := range map[runner.Kind]string{
+		runner.KindSnapshot: to.Snapshot,
+		runner.KindCopy:     to.Copy,
+		runner.KindPrune:    to.Prune,
+	} {
+		d, err := config.ParseDuration(s)
+		if err != nil {
+			return nil, runner.RetryPolicy{}, fmt.Errorf("iab.timeouts.%s: %w", kind, err)
+		}
+		timeouts[kind] = d
+	}
+
+	rc := app.config.IAB.Retry
+	backoff, err := config.ParseDuration(rc.Backoff)
+	if err != nil {
+		return nil, runner.RetryPolicy{}, fmt.Errorf("iab.retry.backoff: %w", err)
+	}
+	maxBackoff, err := config.ParseDuration(rc.MaxBackoff)
+	if err != nil {
+		return nil, runner.RetryPolicy{}, fmt.Errorf("iab.retry.maxBackoff: %w", err)
+	}
+
+	retry := runner.RetryPolicy{
+		Attempts:   rc.Attempts,
+		Backoff:    backoff,
+		MaxBackoff: maxBackoff,
+	}
+	for _, class := range rc.RetryOn {
+		retry.RetryOn = append(retry.RetryOn, runner.ErrorClass(class))
+	}
+
+	return timeouts, retry, nil
+}
+
+// runTopology returns the source and the replication hops of every project and the hosts taking part in the run.
+func (app *application) runTopology() (sources []config.Host, projectHops [][]config.Hop, hosts []config.Host, err error) {
+	_, err = app.GetHostsByRole("target")
+	if err != nil {
+		app.logger.Error("Target Host configuration missing", "error", err)
+		return nil, nil, nil, err
+	}
+
+	seenHosts := map[string]struct{}{}
+	addHost := func(h config.Host) {
+		if _, ok := seenHosts[h.Name]; ok {
+			return
+		}
+		seenHosts[h.Name] = struct{}{}
+		hosts = append(hosts, h)
+	}
+
+	sources = make([]config.Host, len(app.config.Projects))
+	projectHops = make([][]config.Hop, len(app.config.Projects))
+	for i, project := range app.config.Projects {
+		source, err := app.config.ProjectSource(project)
+		if err != nil {
+			app.logger.Error("Source Host configuration missing", "project", project.Name, "error", err)
+			return nil, nil, nil, err
+		}
+		sources[i] = source
+		addHost(source)
+
+		projectHops[i] = app.config.ReplicationHops(source)
+		for _, hop := range projectHops[i] {
+			app.logger.Debug("replication hop", "project", project.Name, "from", hop.From.Name, "to", hop.To.Name, "depth", hop.Depth)
+			addHost(hop.To)
+		}
+	}
+	return sources, projectHops, hosts, nil
+}
+
+// buildPlan creates the snapshot, copy and prune tasks for the projects, which are the
+// configured projects with their discovered resources added, along the topology of runTopology.
+func (app *application) buildPlan(projects []config.Project, sources []config.Host, projectHops [][]config.Hop) runner.Plan {
+	maxDepth := 0
+	for _, hops := range projectHops {
+		for _, hop := range hops {
+			maxDepth = max(maxDepth, hop.Depth)
+		}
+	}
+
 	plan := runner.Plan{}
 
 	// Phase 1: All Snapshots
-	for _, project := range app.config.Projects {
+	plan.BeginPhase("snapshot")
+	for i, project := range projects {
+		if project.DiscoverErr != nil {
+			// fails the run, the listed resources are still backed up; if the instances
+			// could not be listed, without their user.iab.* keys
+			plan.Add(runner.InvalidTask{
+				ProjectName: project.Name,
+				Resource:    "project/" + project.Name,
+				HostName:    sources[i].Name,
+				Err:         project.DiscoverErr,
+			})
+		}
 		for _, vol := range project.Volumes {
 			plan.Add(runner.VolumeSnapshotTask{
 				ProjectName: project.Name,
 				PoolName:    vol.Storage,
 				VolumeName:  vol.Name,
+				HostName:    sources[i].Name,
 			})
 		}
 		for _, inst := range project.Instances {
+			if inst.KeysErr != nil {
+				// the instance is not copied or pruned with settings it did not ask for
+				plan.Add(runner.InvalidTask{
+					ProjectName: project.Name,
+					Resource:    "instance/" + inst.Name,
+					HostName:    sources[i].Name,
+					Err:         inst.KeysErr,
+				})
+				continue
+			}
+			stop := inst.StopInstance(app.config.IAB.StopInstance)
 			plan.Add(runner.InstanceSnapshotTask{
 				ProjectName:  project.Name,
 				InstanceName: inst.Name,
+				HostName:     sources[i].Name,
+				Stop:         &stop,
 			})
 		}
 	}
 
-	// Phase 2: All Copies
-	for _, project := range app.config.Projects {
-		for _, vol := range project.Volumes {
-			plan.Add(runner.VolumeCopyTask{
-				ProjectName: project.Name,
-				PoolName:    vol.Storage,
-				VolumeName:  vol.Name,
-				Mode:        project.Mode,
-			})
+	// Phase 2: All Copies, hop by hop so chained targets copy from a refreshed upstream
+	for depth := 1; depth <= maxDepth; depth++ {
+		// projects in whole-project mode are replicated before their instances and volumes
+		var syncs []runner.Task
+		for i, project := range projects {
+			if !project.All {
+				continue
+			}
+			for _, hop := range projectHops[i] {
+				if hop.Depth != depth {
+					continue
+				}
+				task := runner.ProjectSyncTask{
+					ProjectName:   project.Name,
+					SourceName:    hop.From.Name,
+					TargetName:    hop.To.Name,
+					OriginName:    sources[i].Name,
+					Networks:      project.Networks,
+					NetworkACLs:   project.NetworkACLs,
+					SourceProject: project.ProjectOn(hop.From),
+					TargetProject: project.ProjectOn(hop.To),
+				}
+				// the profiles of the instances get the same pools and volume names
+				if hop.From.Role == "source" {
+					task.VolumePrefix, task.VolumeSuffix = project.TargetPrefix, project.TargetSuffix
+					task.Pools, task.VolumePools = app.config.IAB.PoolMapping, volumePools(app.config, hop.To, project)
+				}
+				syncs = append(syncs, task)
+			}
 		}
-		for _, inst := range project.Instances {
-			plan.Add(runner.InstanceCopyTask{
-				ProjectName:    project.Name,
-				InstanceName:   inst.Name,
-				Mode:           project.Mode,
-				PoolName:       inst.Storage,
-				ExcludeDevices: inst.ExcludeDevices,
-			})
+		if len(syncs) > 0 {
+			plan.BeginPhase(fmt.Sprintf("project (hop %d)", depth))
+			for _, t := range syncs {
+				plan.Add(t)
+			}
 		}
-	}
 
-	// Phase 3: All Prunes
-	for _, project := range app.config.Projects {
-		for _, vol := range project.Volumes {
-			srcPol := app.config.ResolveRetention("source", project.Name, config.RetentionVolumes, vol.Name)
-			tgtPol := app.config.ResolveRetention("target", project.Name, config.RetentionVolumes, vol.Name)
-			if app.config.IAB.IncusOSfix {
-				tgtPol = srcPol
+		// volumes first, the instances of the hop attach them on the target
+		var volumeCopies, instanceCopies []runner.Task
+		for i, project := range projects {
+			for _, hop := range projectHops[i] {
+				if hop.Depth != depth {
+					continue
+				}
+				for _, vol := range project.Volumes {
+					volumeCopies = append(volumeCopies, runner.VolumeCopyTask{
+						ProjectName:   project.Name,
+						PoolName:      vol.Storage,
+						VolumeName:    vol.Name,
+						SourceName:    hop.From.Name,
+						TargetName:    hop.To.Name,
+						OriginName:    sources[i].Name,
+						Mode:          project.Mode,
+						SourceProject: project.ProjectOn(hop.From),
+						TargetProject: project.ProjectOn(hop.To),
+						SourcePool:    app.config.VolumePoolOn(hop.From, vol),
+						TargetPool:    app.config.VolumePoolOn(hop.To, vol),
+						TargetVolume:  project.NameOn(hop.To, vol.Name),
+					})
+				}
+				for _, inst := range project.Instances {
+					if inst.KeysErr != nil {
+						continue
+					}
+					task := runner.InstanceCopyTask{
+						ProjectName:    project.Name,
+						InstanceName:   inst.Name,
+						SourceName:     hop.From.Name,
+						TargetName:     hop.To.Name,
+						OriginName:     sources[i].Name,
+						Mode:           project.Mode,
+						PoolName:       inst.TargetPool(),
+						RootPool:       cmp.Or(inst.TargetPool(), app.config.PoolOn(hop.To, inst.RootPool)),
+						ExcludeDevices: inst.DevicesToExclude(),
+						SourceProject:  project.ProjectOn(hop.From),
+						TargetProject:  project.ProjectOn(hop.To),
+						TargetInstance: project.NameOn(hop.To, inst.Name),
+					}
+					// later hops copy the replica, its volumes are renamed and moved already
+					if hop.From.Role == "source" {
+						task.VolumePrefix, task.VolumeSuffix = project.TargetPrefix, project.TargetSuffix
+						task.Pools, task.VolumePools = app.config.IAB.PoolMapping, volumePools(app.config, hop.To, project)
+					}
+					instanceCopies = append(instanceCopies, task)
+				}
 			}
-			plan.Add(runner.VolumePruneTask{
-				ProjectName:  project.Name,
-				PoolName:     vol.Storage,
-				VolumeName:   vol.Name,
-				SourcePolicy: srcPol,
-				TargetPolicy: tgtPol,
-			})
 		}
-		for _, inst := range project.Instances {
-			srcPol := app.config.ResolveRetention("source", project.Name, config.RetentionInstances, inst.Name)
-			tgtPol := app.config.ResolveRetention("target", project.Name, config.RetentionInstances, inst.Name)
-			if app.config.IAB.IncusOSfix {
-				tgtPol = srcPol
+		for _, phase := range []struct {
+			name  string
+			tasks []runner.Task
+		}{{"copy volumes", volumeCopies}, {"copy instances", instanceCopies}} {
+			if len(phase.tasks) == 0 {
+				continue
+			}
+			plan.BeginPhase(fmt.Sprintf("%s (hop %d)", phase.name, depth))
+			for _, t := range phase.tasks {
+				plan.Add(t)
 			}
-			plan.Add(runner.InstancePruneTask{
-				ProjectName:  project.Name,
-				InstanceName: inst.Name,
-				SourcePolicy: srcPol,
-				TargetPolicy: tgtPol,
-			})
 		}
 	}
 
-	// get connection information
-	sourceConfig, err := app.GetHostByRole("source")
-	if err != nil {
-		app.logger.Error("Source Host configuration missing", "error", err)
-		return err
-	}
-
-	targetConfig, err := app.GetHostByRole("target")
-	if err != nil {
-		app.logger.Error("Target Host configuration missing", "error", err)
-		return err
-	}
-
-	// establish connection to source and target
-	app.logger.Info("Establishing connection to IncusOS-Server")
-
-	// SOURCE CONNECTION
-	app.logger.Info("Connecting to Source", "url", sourceConfig.URL)
-	sourceClient, err := app.ConnectToHost(sourceConfig)
-	if err != nil {
-		app.logger.Error("Connection to Source failed", "url", sourceConfig.URL, "error", err)
-		return err
-	}
+	// Phase 3: All Prunes, source first, then every target
+	plan.BeginPhase("prune")
+	for i, project := range projects {
+		pruneHosts := []config.Host{sources[i]}
+		for _, hop := range projectHops[i] {
+			pruneHosts = append(pruneHosts, hop.To)
+		}
 
-	// TARGET CONNECTION
-	app.logger.Info("Connecting to target", "url", targetConfig.URL)
-	targetClient, err := app.ConnectToHost(targetConfig)
-	if err != nil {
-		app.logger.Error("Connection to Target failed", "url", targetConfig.URL, "error", err)
-		return err
+		for _, host := range pruneHosts {
+			for _, vol := range project.Volumes {
+				pol := app.config.ResolveRetention(host, project.Name, config.RetentionVolumes, vol.Name)
+				if host.Role == "target" && app.config.IAB.IncusOSfix {
+					pol = app.config.ResolveRetention(sources[i], project.Name, config.RetentionVolumes, vol.Name)
+				}
+				plan.Add(runner.VolumePruneTask{
+					ProjectName: project.Name,
+					PoolName:    vol.Storage,
+					VolumeName:  vol.Name,
+					Role:        host.Role,
+					HostName:    host.Name,
+					OriginName:  sources[i].Name,
+					Policy:      pol,
+					HostProject: project.ProjectOn(host),
+					HostPool:    app.config.VolumePoolOn(host, vol),
+					HostVolume:  project.NameOn(host, vol.Name),
+				})
+			}
+			for _, inst := range project.Instances {
+				if inst.KeysErr != nil {
+					continue
+				}
+				pol := app.config.ResolveInstanceRetention(host, project.Name, inst)
+				// an explicit user.iab.retention.target key of the instance still wins
+				_, targetKey := inst.Keys.Retention["target"]
+				if host.Role == "target" && app.config.IAB.IncusOSfix && !targetKey {
+					pol = app.config.ResolveInstanceRetention(sources[i], project.Name, inst)
+				}
+				plan.Add(runner.InstancePruneTask{
+					ProjectName:  project.Name,
+					InstanceName: inst.Name,
+					Role:         host.Role,
+					HostName:     host.Name,
+					OriginName:   sources[i].Name,
+					Policy:       pol,
+					HostProject:  project.ProjectOn(host),
+					HostInstance: project.NameOn(host, inst.Name),
+				})
+			}
+		}
 	}
 
-	srcInfo, _, err := sourceClient.GetServer()
-	if err != nil {
-		app.logger.Error("Cannot fetch source server info", "error", err)
-		return err
-	}
-	app.logger.Info("Source Server Connected",
-		"name", srcInfo.Environment.ServerName,
-		"version", srcInfo.Environment.ServerVersion)
+	return plan
+}
 
-	tgtInfo, _, err := targetClient.GetServer()
-	if err != nil {
-		app.logger.Error("Cannot fetch target server info", "error", err)
-		return err
+// volumePools maps the volumes of the project with their own targetStorage to their pool on target,
+// keyed "pool/volume" as in the disk devices of the instances on the source.
+func volumePools(cfg config.Config, target config.Host, project config.Project) map[string]string {
+	pools := make(map[string]string)
+	for _, vol := range project.Volumes {
+		if vol.TargetStorage != "" {
+			pools[vol.Storage+"/"+vol.Name] = cfg.VolumePoolOn(target, vol)
+		}
 	}
-	app.logger.Info("Target Server Connected",
-		"name", tgtInfo.Environment.ServerName,
-		"version", tgtInfo.Environment.ServerVersion)
-
-	exec := &runner.ExecCtx{
-		Ctx:               context.Background(),
-		Logger:            app.logger,
-		Source:            sourceClient,
-		Target:            targetClient,
-		DryRunCopy:        app.config.IAB.DryRunCopy,
-		DryRunPrune:       app.config.IAB.DryRunPrune,
-		StopInstances:     app.config.IAB.StopInstance,
-		VolumeSnapshots:   make(map[string]*api.StorageVolume),
-		InstanceSnapshots: make(map[string]*api.Instance),
-	}
-	return plan.Execute(exec)
+	return pools
 }
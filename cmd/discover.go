@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/rbnhln/incusAutobackup/internal/backup"
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// resolveProjects returns the configured projects with the instances and volumes picked by their
+// selectors added, looked up on the source of each project. Listed resources keep their settings.
+// Projects with all: true select every instance and volume. The user.iab.* keys of all instances
+// are read from the source as well. Discovery errors are set in DiscoverErr of the project.
+func (app *application) resolveProjects(clients map[string]incus.InstanceServer) ([]config.Project, []runner.Discovered, error) {
+	projects := make([]config.Project, len(app.config.Projects))
+	var discovered []runner.Discovered
+
+	for i, project := range app.config.Projects {
+		projects[i] = project
+		sel := project.Discover
+		if project.All {
+			sel.Instances.All, sel.Volumes.All = true, true
+		}
+		if len(project.Instances) == 0 && !sel.Instances.Enabled() && !sel.Volumes.Enabled() {
+			continue
+		}
+
+		source, err := app.config.ProjectSource(project)
+		if err != nil {
+			return nil, nil, err
+		}
+		client, ok := clients[source.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("no connection for host %q", source.Name)
+		}
+		client = client.UseProject(project.Name)
+
+		d := runner.Discovered{Project: project.Name}
+		if len(project.Instances) > 0 || sel.Instances.Enabled() {
+			projects[i].Instances = slices.Clone(project.Instances)
+			instances, err := client.GetInstances(api.InstanceTypeAny)
+			if err != nil {
+				// nothing can be discovered, the listed instances are backed up with their config
+				// settings only, as their user.iab.* keys are unknown
+				err = fmt.Errorf("list instances of project %s on %s: %w", project.Name, source.Name, err)
+				app.logger.Error("cannot list instances", "project", project.Name, "source", source.Name, "error", err)
+				projects[i].DiscoverErr = err
+			}
+			for _, inst := range instances {
+				listed := slices.ContainsFunc(project.Instances, func(i config.Instance) bool { return i.Name == inst.Name })
+				// keys set through profiles count as well
+				if listed || !sel.Instances.Match(inst.Name, inst.ExpandedConfig) {
+					continue
+				}
+				projects[i].Instances = append(projects[i].Instances, config.Instance{
+					Name:           inst.Name,
+					Storage:        sel.Instances.Storage,
+					ExcludeDevices: sel.Instances.ExcludeDevices,
+				})
+				d.Instances = append(d.Instances, inst.Name)
+			}
+			app.applyInstanceKeys(project.Name, projects[i].Instances, instances)
+		}
+
+		if sel.Volumes.Enabled() {
+			projects[i].Volumes = slices.Clone(project.Volumes)
+			pools, err := client.GetStoragePools()
+			if err != nil {
+				err = fmt.Errorf("discover volumes of project %s on %s: %w", project.Name, source.Name, err)
+				app.logger.Error("cannot discover volumes", "project", project.Name, "source", source.Name, "error", err)
+				projects[i].DiscoverErr = errors.Join(projects[i].DiscoverErr, err)
+			}
+			for _, pool := range pools {
+				if len(sel.Volumes.Pools) > 0 && !slices.Contains(sel.Volumes.Pools, pool.Name) {
+					continue
+				}
+				volumes, err := client.GetStoragePoolVolumes(pool.Name)
+				if err != nil {
+					err = fmt.Errorf("discover volumes of project %s in pool %s on %s: %w", project.Name, pool.Name, source.Name, err)
+					app.logger.Error("cannot discover volumes", "project", project.Name, "pool", pool.Name, "error", err)
+					projects[i].DiscoverErr = errors.Join(projects[i].DiscoverErr, err)
+					continue
+				}
+				for _, vol := range volumes {
+					if vol.Type != "custom" || vol.ContentType == "iso" {
+						continue
+					}
+					listed := slices.ContainsFunc(project.Volumes, func(v config.Volume) bool { return v.Name == vol.Name && v.Storage == pool.Name })
+					if listed || !sel.Volumes.Match(vol.Name, vol.Config) {
+						continue
+					}
+					projects[i].Volumes = append(projects[i].Volumes, config.Volume{Name: vol.Name, Storage: pool.Name})
+					d.Volumes = append(d.Volumes, pool.Name+"/"+vol.Name)
+				}
+			}
+		}
+
+		if !sel.Instances.Enabled() && !sel.Volumes.Enabled() {
+			continue
+		}
+		app.logger.Info("discovered resources",
+			"project", project.Name,
+			"source", source.Name,
+			"instances", d.Instances,
+			"volumes", d.Volumes,
+		)
+		discovered = append(discovered, d)
+	}
+
+	return projects, discovered, nil
+}
+
+// applyInstanceKeys sets the settings of the user.iab.* keys and the root pool of every planned instance
+// found on the source. Invalid keys are logged and fail the tasks of that instance only.
+func (app *application) applyInstanceKeys(project string, planned []config.Instance, found []api.Instance) {
+	for i := range planned {
+		idx := slices.IndexFunc(found, func(inst api.Instance) bool { return inst.Name == planned[i].Name })
+		if idx < 0 {
+			continue // the snapshot reports the missing instance
+		}
+		planned[i].RootPool = backup.RootDiskPool(&found[idx])
+		keys, err := config.ParseInstanceKeys(found[idx].Config)
+		if err != nil {
+			app.logger.Error("invalid instance keys", "project", project, "instance", planned[i].Name, "error", err)
+			planned[i].KeysErr = err
+			continue
+		}
+		planned[i].Keys = keys
+	}
+}
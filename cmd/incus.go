@@ -5,6 +5,7 @@ import (
 	"os"
 
 	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
 	"github.com/rbnhln/incusAutobackup/internal/config"
 )
 
@@ -18,6 +19,56 @@ func (app *application) GetHostByRole(role string) (config.Host, error) {
 	return config.Host{}, fmt.Errorf("no host with role '%s' found in config", role)
 }
 
+func (app *application) GetHostsByRole(role string) ([]config.Host, error) {
+	hosts := app.config.HostsByRole(role)
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no host with role '%s' found in config", role)
+	}
+
+	return hosts, nil
+}
+
+// ConnectToHosts connects to every given host and returns the clients keyed by host name.
+// An unreachable target does not stop the run, its error is returned in unreachable and only its
+// tasks fail. An unreachable source is an error.
+func (app *application) ConnectToHosts(hosts []config.Host) (clients map[string]incus.InstanceServer, unreachable map[string]error, err error) {
+	clients = make(map[string]incus.InstanceServer, len(hosts))
+	unreachable = make(map[string]error)
+	for _, host := range hosts {
+		app.logger.Info("Connecting to host", "host", host.Name, "role", host.Role, "url", host.URL)
+		client, info, err := app.connectAndInspect(host)
+		if err != nil {
+			app.logger.Error("Connection to host failed", "host", host.Name, "url", host.URL, "error", err)
+			if host.Role != "target" {
+				return nil, nil, err
+			}
+			unreachable[host.Name] = err
+			continue
+		}
+		app.logger.Info("Server Connected",
+			"host", host.Name,
+			"role", host.Role,
+			"name", info.Environment.ServerName,
+			"version", info.Environment.ServerVersion)
+
+		clients[host.Name] = client
+	}
+
+	return clients, unreachable, nil
+}
+
+func (app *application) connectAndInspect(host config.Host) (incus.InstanceServer, *api.Server, error) {
+	client, err := app.ConnectToHost(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, _, err := client.GetServer()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot fetch server info of host %s: %w", host.Name, err)
+	}
+	return client, info, nil
+}
+
 func (app *application) ConnectToHost(host config.Host) (incus.InstanceServer, error) {
 	iabDir := app.config.IAB.IABCredDir
 	if iabDir == "" {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/lock"
+)
+
+// lockHosts takes the distributed lock on every source and target host taking part in the run.
+// Unreachable targets are skipped, their tasks fail anyway. On error, locks taken so far are released again.
+// The locks are renewed every third of the TTL until release is called, so they do not expire during long runs.
+func (app *application) lockHosts(hosts []config.Host, clients map[string]incus.InstanceServer) (release func(), err error) {
+	ttl, err := app.config.IAB.Lock.TTLDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	var held []*lock.Server
+	releaseHeld := func() {
+		for _, l := range held {
+			err := l.Release()
+			if err != nil {
+				app.logger.Warn("failed to release server lock", "error", err)
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, host := range hosts {
+		client, ok := clients[host.Name]
+		if !ok {
+			continue
+		}
+		l, err := lock.AcquireServer(client, host.Name, app.config.IAB.UUID, ttl, now)
+		if err != nil {
+			releaseHeld()
+			return nil, err
+		}
+		app.logger.Debug("server lock acquired", "host", host.Name, "key", lock.ServerKey, "ttl", ttl)
+		held = append(held, l)
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(max(ttl/3, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				for _, l := range held {
+					err := l.Renew(ttl, now)
+					if err != nil {
+						app.logger.Warn("failed to renew server lock", "error", err)
+						continue
+					}
+					app.logger.Debug("server lock renewed", "key", lock.ServerKey, "ttl", ttl)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		releaseHeld()
+	}, nil
+}
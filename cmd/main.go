@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
+	"syscall"
 
 	"github.com/google/uuid"
 	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/logging"
+	"github.com/rbnhln/incusAutobackup/internal/notifications"
 	"github.com/rbnhln/incusAutobackup/internal/vcs"
 )
 
@@ -26,18 +32,16 @@ var (
 	version = vcs.Version()
 )
 
+// exitNotificationFailed is the exit code of a successful run whose notifications could not be delivered.
+const exitNotificationFailed = 3
+
 type application struct {
 	logger *slog.Logger
 	config config.Config
 }
 
 func main() {
-	opts := &slog.HandlerOptions{
-		Level:     slog.LevelInfo,
-		AddSource: true,
-	}
-
-	logger := slog.New(slog.NewTextHandler(os.Stdout, opts))
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 
 	if len(os.Args) > 1 && os.Args[1] == "onboard" {
 		cfgDir, err := os.UserConfigDir()
@@ -87,12 +91,94 @@ func main() {
 		os.Exit(0)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+		format := planFlags.String("format", "table", "Output format: table|json")
+		iosfix := planFlags.Bool("iOSfix", false, "applies the source retention policy to the target")
+		planFlags.String("log-level", "warn", "Log level: debug|info|warn|error")
+
+		_ = planFlags.Parse(os.Args[2:])
+
+		// the plan is printed to stdout, logs go to stderr
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+		cfg, err := config.Load("./config.json")
+		if err != nil {
+			logger.Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+		cfg.IAB.IncusOSfix = *iosfix
+
+		runLogger, logCloser, err := newLogger(planFlags, cfg.IAB.Log, os.Stderr)
+		if err != nil {
+			logger.Error("failed to set up logging", "error", err)
+			os.Exit(1)
+		}
+		logger = runLogger
+
+		app := &application{
+			config: *cfg,
+			logger: logger,
+		}
+
+		err = app.preview(*format, os.Stdout)
+		if err != nil {
+			logger.Error(err.Error())
+			_ = logCloser.Close()
+			os.Exit(1)
+		}
+		_ = logCloser.Close()
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		statusFlags := flag.NewFlagSet("status", flag.ExitOnError)
+		format := statusFlags.String("format", "table", "Output format: table|json")
+		statusFlags.String("log-level", "warn", "Log level: debug|info|warn|error")
+
+		_ = statusFlags.Parse(os.Args[2:])
+
+		// the status is printed to stdout, logs go to stderr
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+		cfg, err := config.Load("./config.json")
+		if err != nil {
+			logger.Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		runLogger, logCloser, err := newLogger(statusFlags, cfg.IAB.Log, os.Stderr)
+		if err != nil {
+			logger.Error("failed to set up logging", "error", err)
+			os.Exit(1)
+		}
+		logger = runLogger
+
+		app := &application{
+			config: *cfg,
+			logger: logger,
+		}
+
+		err = app.status(*format, os.Stdout)
+		if err != nil {
+			logger.Error(err.Error())
+			_ = logCloser.Close()
+			os.Exit(1)
+		}
+		_ = logCloser.Close()
+		os.Exit(0)
+	}
+
 	dryRunPrune := flag.Bool("dryRunPrune", false, "do not perform the pruning step")
 	dryRuneCopy := flag.Bool("dryRunCopy", false, "do not perform the copy and snapshot step")
 	dryRun := flag.Bool("dryRun", false, "Do not perform any pruning, copy or snapshot actions")
-	iosfix := flag.Bool("iOSfix", true, "applies the source retention policy to the target")
+	iosfix := flag.Bool("iOSfix", false, "applies the source retention policy to the target")
 	displayVersion := flag.Bool("version", false, "Display version and exit")
-	logLevel := flag.String("log-level", "info", "Log level: debug|info|warn|error")
+	flag.String("log-level", "info", "Log level: debug|info|warn|error")
+	flag.String("log-format", "text", "Log format: text|json")
+	flag.String("log-file", "", "Write logs to this file instead of stdout, rotated by size")
+	flag.Bool("log-source", false, "Add the source file and line to every log record")
+	reportFormat := flag.String("report", "", "Print the run report to stdout: json (logs are written to stderr)")
 
 	flag.Parse()
 
@@ -106,10 +192,16 @@ func main() {
 		os.Exit(0)
 	}
 
-	logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     parseLogLevel(*logLevel),
-		AddSource: true,
-	}))
+	if *reportFormat != "" && *reportFormat != "json" {
+		logger.Error("invalid report format, expected json", "report", *reportFormat)
+		os.Exit(1)
+	}
+
+	// keep stdout clean for the report
+	logOut := os.Stdout
+	if *reportFormat != "" {
+		logOut = os.Stderr
+	}
 
 	cfg, err := config.Load("./config.json")
 	if err != nil {
@@ -117,34 +209,71 @@ func main() {
 		os.Exit(1)
 	}
 
+	runLogger, logCloser, err := newLogger(flag.CommandLine, cfg.IAB.Log, logOut)
+	if err != nil {
+		logger.Error("failed to set up logging", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = logCloser.Close() }()
+	logger = runLogger
+
 	cfg.IAB.DryRunPrune = *dryRunPrune
 	cfg.IAB.DryRunCopy = *dryRuneCopy
 	cfg.IAB.IncusOSfix = *iosfix
+	cfg.IAB.ReportFormat = *reportFormat
 
 	app := &application{
 		config: *cfg,
 		logger: logger,
 	}
 
-	err = app.serve()
+	// SIGINT/SIGTERM abort the run gracefully, a second signal terminates immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		logger.Warn("received stop signal, aborting run")
+	}()
+
+	err = app.serve(ctx)
 	if err != nil {
 		logger.Error(err.Error())
+		_ = logCloser.Close()
+		if errors.Is(err, notifications.ErrDelivery) {
+			os.Exit(exitNotificationFailed)
+		}
 		os.Exit(1)
 	}
-	os.Exit(0)
 }
 
-func parseLogLevel(s string) slog.Level {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "debug":
-		return slog.LevelDebug
-	case "info", "":
-		return slog.LevelInfo
-	case "warn", "warning":
-		return slog.LevelWarn
-	case "error":
-		return slog.LevelError
-	default:
-		return slog.LevelInfo
+// newLogger creates the logger configured in logCfg. The log flags defined in fs take precedence if
+// given on the command line, their defaults fill the unset level and format.
+func newLogger(fs *flag.FlagSet, logCfg config.Log, out io.Writer) (*slog.Logger, io.Closer, error) {
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	value := func(name, configured string) string {
+		f := fs.Lookup(name)
+		if f == nil || (!setFlags[name] && configured != "") {
+			return configured
+		}
+		return f.Value.String()
 	}
+
+	logCfg.Level = value("log-level", logCfg.Level)
+	logCfg.Format = value("log-format", logCfg.Format)
+	if setFlags["log-file"] {
+		logCfg.File = fs.Lookup("log-file").Value.String()
+	}
+	if setFlags["log-source"] {
+		logCfg.Source = fs.Lookup("log-source").Value.String() == "true"
+	}
+
+	return logging.New(logging.Options{
+		Format:     logCfg.Format,
+		Level:      logging.ParseLevel(logCfg.Level),
+		AddSource:  logCfg.Source,
+		File:       logCfg.File,
+		MaxSize:    logCfg.MaxSizeBytes(),
+		MaxBackups: logCfg.Backups(),
+	}, out)
 }
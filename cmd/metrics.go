@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/metrics"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// exportMetrics writes the Prometheus textfile and pushes to the Pushgateway, if configured.
+// Failures are only logged, like the run report.
+func (app *application) exportMetrics(ctx context.Context, report *runner.Report, success bool) {
+	cfg := app.config.IAB.Metrics
+	if cfg.Textfile == "" && cfg.PushgatewayURL == "" {
+		return
+	}
+
+	// the last success of resources which failed this time is kept from the previous textfile
+	var last map[string]float64
+	if cfg.Textfile != "" {
+		var err error
+		last, err = metrics.ReadLastSuccess(cfg.Textfile)
+		if err != nil {
+			app.logger.Warn("failed to read previous metrics", "path", cfg.Textfile, "error", err)
+		}
+	}
+	set := metrics.FromRun(report, success, time.Now(), last)
+
+	if cfg.Textfile != "" {
+		err := metrics.WriteTextfile(cfg.Textfile, set)
+		if err != nil {
+			app.logger.Error("failed to write metrics textfile", "path", cfg.Textfile, "error", err)
+		} else {
+			app.logger.Info("metrics written", "path", cfg.Textfile)
+		}
+	}
+
+	if cfg.PushgatewayURL != "" {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		err := metrics.Push(ctx, http.DefaultClient, cfg.PushgatewayURL, cfg.JobName(), set)
+		if err != nil {
+			app.logger.Error("failed to push metrics", "url", cfg.PushgatewayURL, "error", err)
+		} else {
+			app.logger.Info("metrics pushed", "url", cfg.PushgatewayURL)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// preview prints the plan of a run with the effect of every task, without changing anything.
+func (app *application) preview(format string, w io.Writer) error {
+	// flags are checked before connecting to the hosts
+	if !slices.Contains([]string{"table", "json", ""}, format) {
+		return fmt.Errorf("unknown format %q, expected table or json", format)
+	}
+
+	err := app.config.Validate()
+	if err != nil {
+		return err
+	}
+
+	sources, projectHops, hosts, err := app.runTopology()
+	if err != nil {
+		return err
+	}
+
+	clients, unreachable, err := app.ConnectToHosts(hosts)
+	if err != nil {
+		return err
+	}
+
+	projects, _, err := app.resolveProjects(clients)
+	if err != nil {
+		return err
+	}
+	plan := app.buildPlan(projects, sources, projectHops)
+
+	exec := &runner.ExecCtx{
+		Ctx:           context.Background(),
+		Logger:        app.logger,
+		Hosts:         clients,
+		Unreachable:   unreachable,
+		StopInstances: app.config.IAB.StopInstance,
+		Snapshots:     runner.NewSnapshotStore(),
+	}
+	pv := plan.Preview(exec)
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pv)
+	}
+	return printPreviewTable(w, pv)
+}
+
+func printPreviewTable(w io.Writer, pv runner.PlanPreview) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tKIND\tPROJECT\tRESOURCE\tHOST\tACTION")
+
+	for _, phase := range pv.Phases {
+		for _, t := range phase.Tasks {
+			host := t.Host
+			if t.Source != "" {
+				host = t.Source + " -> " + t.Host
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", phase.Name, t.Kind, t.Project, t.Resource, host, previewAction(t))
+		}
+	}
+	return tw.Flush()
+}
+
+// previewAction summarizes a task preview in one line.
+func previewAction(t runner.TaskPreview) string {
+	if t.Error != "" {
+		return "ERROR: " + t.Error
+	}
+
+	var parts []string
+	switch t.Kind {
+	case runner.KindSnapshot:
+		parts = append(parts, "create "+t.Snapshot)
+		if t.StopInstance {
+			parts = append(parts, "stop and restart instance")
+		}
+	case runner.KindCopy:
+		if strings.HasPrefix(t.Resource, "project/") {
+			if len(t.Changes) == 0 {
+				return "up to date"
+			}
+			return strings.Join(t.Changes, ", ")
+		}
+		action := "initial copy"
+		if t.Refresh {
+			action = "refresh"
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", action, t.Mode))
+		if t.TargetPool != "" {
+			parts = append(parts, "root pool "+t.TargetPool)
+		}
+		if len(t.Devices) > 0 {
+			parts = append(parts, "devices "+strings.Join(slices.Sorted(maps.Keys(t.Devices)), ","))
+		}
+		for _, name := range slices.Sorted(maps.Keys(t.DroppedDevices)) {
+			parts = append(parts, fmt.Sprintf("drop %s (%s)", name, t.DroppedDevices[name]))
+		}
+	case runner.KindPrune:
+		if t.Policy == "" {
+			parts = append(parts, fmt.Sprintf("retention disabled, keep all %d", len(t.Keep)))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("policy %s: keep %d, remove %d", t.Policy, len(t.Keep), len(t.Remove)))
+		if len(t.Remove) > 0 {
+			parts = append(parts, "remove "+strings.Join(t.Remove, ","))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -8,15 +9,22 @@ import (
 
 	incus "github.com/lxc/incus/v6/client"
 	"github.com/lxc/incus/v6/shared/api"
+	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
-func SnapshotInstance(logger *slog.Logger, source incus.InstanceServer, instanceName string, stopIfRunning bool) (*api.Instance, error) {
+// SnapshotInstance creates an IAB snapshot of the instance and returns the instance and the snapshot name.
+func SnapshotInstance(ctx context.Context, logger *slog.Logger, source incus.InstanceServer, instanceName string, stopIfRunning bool) (*api.Instance, string, error) {
 	logger = logger.With("instance", instanceName)
 
+	err := ctx.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
 	// 1 Check if instance exists
 	inst, _, err := source.GetInstance(instanceName)
 	if err != nil {
-		return nil, fmt.Errorf("get source instance %s failed: %w", instanceName, err)
+		return nil, "", fmt.Errorf("get source instance %s failed: %w", instanceName, err)
 	}
 
 	// 2 Optional: Stop if running
@@ -24,29 +32,22 @@ func SnapshotInstance(logger *slog.Logger, source incus.InstanceServer, instance
 	if stopIfRunning {
 		state, _, err := source.GetInstanceState(instanceName)
 		if err != nil {
-			return nil, fmt.Errorf("get instance state %s failed: %w", instanceName, err)
+			return nil, "", fmt.Errorf("get instance state %s failed: %w", instanceName, err)
 		}
 
 		if state != nil && state.Status == "Running" {
 			wasRunning = true
-			logger.Info("stopping instance for snapshot/copy")
-
-			op, err := source.UpdateInstanceState(instanceName, api.InstanceStatePut{
-				Action:  "stop",
-				Timeout: 300,
-				Force:   false,
-			}, "")
-			if err != nil {
-				return nil, fmt.Errorf("stop instance %s failed: %w", instanceName, err)
-			}
-			err = op.Wait()
-			if err != nil {
-				return nil, fmt.Errorf("stop instance %s operation failed: %w", instanceName, err)
-			}
 		}
 	}
+	// registered before stopping: an aborted stop may still take effect on the server
 	if stopIfRunning && wasRunning {
 		defer func() {
+			state, _, err := source.GetInstanceState(instanceName)
+			if err == nil && state.Status == "Running" {
+				return
+			}
+
+			// the restart is not bound to ctx, a cancelled run must not leave the instance stopped
 			logger.Info("starting instance after snapshot/copy")
 			op, err := source.UpdateInstanceState(instanceName, api.InstanceStatePut{
 				Action:  "start",
@@ -63,8 +64,25 @@ func SnapshotInstance(logger *slog.Logger, source incus.InstanceServer, instance
 		}()
 	}
 
+	if wasRunning {
+		logger.Info("stopping instance for snapshot/copy")
+
+		op, err := source.UpdateInstanceState(instanceName, api.InstanceStatePut{
+			Action:  "stop",
+			Timeout: 300,
+			Force:   false,
+		}, "")
+		if err != nil {
+			return nil, "", fmt.Errorf("stop instance %s failed: %w", instanceName, err)
+		}
+		err = waitOperation(ctx, op)
+		if err != nil {
+			return nil, "", fmt.Errorf("stop instance %s operation failed: %w", instanceName, err)
+		}
+	}
+
 	// 3 Create Snapshot
-	snapshotName := fmt.Sprintf("IAB_%s", time.Now().Format("20060102-150405"))
+	snapshotName := retention.IABSnapshotName(time.Now())
 	logger.Info("creating instance snapshot", "snapshot", snapshotName)
 
 	opSnap, err := source.CreateInstanceSnapshot(instanceName, api.InstanceSnapshotsPost{
@@ -72,21 +90,52 @@ func SnapshotInstance(logger *slog.Logger, source incus.InstanceServer, instance
 		Stateful: false,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("create snapshot for instance %s failed: %w", instanceName, err)
+		return nil, "", fmt.Errorf("create snapshot for instance %s failed: %w", instanceName, err)
 	}
-	err = opSnap.Wait()
+	err = waitOperation(ctx, opSnap)
 	if err != nil {
-		return nil, fmt.Errorf("create snapshot for instance %s operation failed: %w", instanceName, err)
+		return nil, "", fmt.Errorf("create snapshot for instance %s operation failed: %w", instanceName, err)
 	}
 
-	return inst, nil
+	return inst, snapshotName, nil
+}
+
+// DeviceOptions controls how the devices of an instance are adapted to the target.
+type DeviceOptions struct {
+	Exclude []string // names of the devices to drop
+
+	// renaming of the custom volumes attached as disks, like the volumes on the target
+	VolumePrefix string
+	VolumeSuffix string
+
+	// pools of the root disk and attached custom volumes on the target
+	Pools       map[string]string // pool on the source -> pool on the target
+	VolumePools map[string]string // "pool/volume" on the source -> pool on the target, before Pools
+}
+
+// pool returns the target pool of the custom volume, or of the root disk if volume is empty.
+func (o DeviceOptions) pool(pool, volume string) string {
+	if p := o.VolumePools[pool+"/"+volume]; volume != "" && p != "" {
+		return p
+	}
+	if p := o.Pools[pool]; p != "" {
+		return p
+	}
+	return pool
 }
 
-func CopyInstance(logger *slog.Logger, source, target incus.InstanceServer, instanceName, projectMode, targetPool string, excludeDevices []string, inst *api.Instance) error {
+// CopyInstance refreshes the instance on target as instanceName and returns the transferred bytes, 0 if unknown.
+// inst is the instance on source, its name may differ from instanceName.
+func CopyInstance(ctx context.Context, logger *slog.Logger, source, target incus.InstanceServer, instanceName, projectMode, targetPool string, devices DeviceOptions, inst *api.Instance) (int64, error) {
 	// 4 Copy to target
 	logger = logger.With("instance", instanceName)
 	logger.Info("copying instance to target")
 
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
 	copyArgs := incus.InstanceCopyArgs{
 		Name:                instanceName,
 		Mode:                projectMode,
@@ -99,33 +148,28 @@ func CopyInstance(logger *slog.Logger, source, target incus.InstanceServer, inst
 
 	// 4.1 Need to change the storage pool, did not find Pool flag in copy args
 	// 4.2 filter devices which are not present on the target host
-	instCopy := *inst
-	instCopy.Devices = cloneDevices(inst.Devices)
-
-	// root disk change
-	if targetPool != "" {
-		applyTargetPoolToRootDisk(instCopy.Devices, targetPool)
-	}
-
-	// sanitize devices for target host, drop with warn if not present
-	err := sanitizeDevicesForTarget(logger, target, instCopy.Devices, excludeDevices)
+	instCopy, dropped, err := instanceForTarget(target, inst, targetPool, devices)
 	if err != nil {
-		return fmt.Errorf("sanitize devices failed: %w", err)
+		return 0, err
+	}
+	for devName, reason := range dropped {
+		logger.Warn("dropping device", "device", devName, "reason", reason)
 	}
 
 	//4.2 Perform Copy
 
 	opCopy, err := target.CopyInstance(source, instCopy, &copyArgs)
 	if err != nil {
-		return fmt.Errorf("copy instance %s to target failed: %w", instanceName, err)
+		return 0, fmt.Errorf("copy instance %s to target failed: %w", instanceName, err)
 	}
-	err = opCopy.Wait()
+	transfer := trackTransfer(opCopy)
+	err = waitRemoteOperation(ctx, opCopy)
 	if err != nil {
-		return fmt.Errorf("copy instance %s operation failed: %w", instanceName, err)
+		return 0, fmt.Errorf("copy instance %s operation failed: %w", instanceName, err)
 	}
 
-	logger.Info("instance sync successful")
-	return nil
+	logger.Info("instance sync successful", "bytes", transfer.Bytes())
+	return transfer.Bytes(), nil
 }
 
 // create deep copy for "security" reasons, no changes on original project
@@ -148,6 +192,31 @@ func cloneDevices(in map[string]map[string]string) map[string]map[string]string
 	return out
 }
 
+// instanceForTarget returns a copy of inst with the root disk moved to targetPool and all devices
+// dropped which are excluded or missing on target. Without targetPool the root disk follows
+// devices.Pools. dropped maps device names to the reason.
+func instanceForTarget(target incus.InstanceServer, inst *api.Instance, targetPool string, devices DeviceOptions) (api.Instance, map[string]string, error) {
+	instCopy := *inst
+	instCopy.Devices = cloneDevices(inst.Devices)
+
+	// root disk change, the root disk may come from a profile
+	if targetPool == "" {
+		if pool := RootDiskPool(inst); pool != "" && devices.pool(pool, "") != pool {
+			targetPool = devices.pool(pool, "")
+		}
+	}
+	if targetPool != "" {
+		applyTargetPoolToRootDisk(instCopy.Devices, targetPool)
+	}
+
+	// sanitize devices for target host, drop if not present
+	dropped, err := sanitizeDevicesForTarget(target, instCopy.Devices, devices)
+	if err != nil {
+		return api.Instance{}, nil, fmt.Errorf("sanitize devices failed: %w", err)
+	}
+	return instCopy, dropped, nil
+}
+
 func applyTargetPoolToRootDisk(devices map[string]map[string]string, pool string) {
 	for devName, dev := range devices {
 		if dev == nil {
@@ -167,15 +236,28 @@ func applyTargetPoolToRootDisk(devices map[string]map[string]string, pool string
 	devices["root"]["pool"] = pool
 }
 
-func sanitizeDevicesForTarget(logger *slog.Logger, target incus.InstanceServer, devices map[string]map[string]string, excludeDevices []string) error {
-	ex := make(map[string]struct{}, len(excludeDevices))
-	for _, n := range excludeDevices {
+// RootDiskPool returns the pool of the root disk of inst, also if it comes from a profile, "" if unknown.
+func RootDiskPool(inst *api.Instance) string {
+	for _, devices := range []map[string]map[string]string{inst.Devices, inst.ExpandedDevices} {
+		for _, dev := range devices {
+			if dev["type"] == "disk" && dev["path"] == "/" {
+				return dev["pool"]
+			}
+		}
+	}
+	return ""
+}
+
+func sanitizeDevicesForTarget(target incus.InstanceServer, devices map[string]map[string]string, opts DeviceOptions) (map[string]string, error) {
+	ex := make(map[string]struct{}, len(opts.Exclude))
+	for _, n := range opts.Exclude {
 		if n == "" {
 			continue
 		}
 		ex[n] = struct{}{}
 	}
 
+	dropped := make(map[string]string)
 	for devName, dev := range devices {
 		if dev == nil {
 			continue
@@ -184,45 +266,83 @@ func sanitizeDevicesForTarget(logger *slog.Logger, target incus.InstanceServer,
 		// first use devices from exclude list
 		_, ok := ex[devName]
 		if ok {
-			logger.Warn("dropping device due to excludeDevices config", "device", devName)
+			dropped[devName] = "excludeDevices config"
 			delete(devices, devName)
 			continue
 		}
 
 		// search for nics to be droped
 		if dev["type"] == "nic" && dev["network"] != "" {
-			netName := dev["network"]
-			_, _, err := target.GetNetwork(netName)
-			if err == nil {
-				continue
+			missing, err := targetNetworkMissing(target, dev["network"])
+			if err != nil {
+				return nil, err
 			}
-			if isNotFound(err) {
-				logger.Warn("dropping nic device due to target network missing", "device", devName, "network", netName)
+			if missing {
+				dropped[devName] = fmt.Sprintf("target network %s missing", dev["network"])
 				delete(devices, devName)
-				continue
 			}
-			return fmt.Errorf("check target network %s failed: %w", netName, err)
+			continue
 		}
 
 		// search for additional volumes which are not present on the target host
 		// if the pool is provided via config it should be sync before automatically
 		if dev["type"] == "disk" && dev["pool"] != "" && dev["source"] != "" && dev["path"] != "/" {
-			pool := dev["pool"]
-			vol := dev["source"]
+			pool := opts.pool(dev["pool"], dev["source"])
+			vol := opts.VolumePrefix + dev["source"] + opts.VolumeSuffix
+			dev["pool"], dev["source"] = pool, vol
 
 			_, _, err := target.GetStoragePoolVolume(pool, "custom", vol)
 			if err == nil {
 				continue
 			}
 			if isNotFound(err) {
-				logger.Warn("dropping disk device due to absence on target host", "device", devName, "pool", pool, "volume", vol, "path", dev["path"])
+				dropped[devName] = fmt.Sprintf("volume %s/%s missing on target host", pool, vol)
 				delete(devices, devName)
 				continue
 			}
-			return fmt.Errorf("check target volume %s/%s failed: %w", pool, vol, err)
+			return nil, fmt.Errorf("check target volume %s/%s failed: %w", pool, vol, err)
 		}
 	}
-	return nil
+	return dropped, nil
+}
+
+// profileForTarget returns a copy of the profile with its devices adapted like those of instances:
+// pools are mapped, attached volumes renamed and nics of networks missing on target dropped, unless
+// the network is in synced. Attached volumes need not exist yet, they are copied after the profiles.
+func profileForTarget(target incus.InstanceServer, put api.ProfilePut, opts DeviceOptions, synced map[string]bool) (api.ProfilePut, map[string]string, error) {
+	put.Devices = cloneDevices(put.Devices)
+	dropped := make(map[string]string)
+
+	for devName, dev := range put.Devices {
+		switch {
+		case dev["type"] == "disk" && dev["pool"] != "" && dev["path"] == "/":
+			dev["pool"] = opts.pool(dev["pool"], "")
+		case dev["type"] == "disk" && dev["pool"] != "" && dev["source"] != "":
+			dev["pool"] = opts.pool(dev["pool"], dev["source"])
+			dev["source"] = opts.VolumePrefix + dev["source"] + opts.VolumeSuffix
+		case dev["type"] == "nic" && dev["network"] != "" && !synced[dev["network"]]:
+			missing, err := targetNetworkMissing(target, dev["network"])
+			if err != nil {
+				return api.ProfilePut{}, nil, err
+			}
+			if missing {
+				dropped[devName] = fmt.Sprintf("target network %s missing", dev["network"])
+				delete(put.Devices, devName)
+			}
+		}
+	}
+	return put, dropped, nil
+}
+
+func targetNetworkMissing(target incus.InstanceServer, netName string) (bool, error) {
+	_, _, err := target.GetNetwork(netName)
+	if err == nil {
+		return false, nil
+	}
+	if isNotFound(err) {
+		return true, nil
+	}
+	return false, fmt.Errorf("check target network %s failed: %w", netName, err)
 }
 
 func isNotFound(err error) bool {
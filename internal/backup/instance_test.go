@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func TestDeviceOptionsPool(t *testing.T) {
+	opts := DeviceOptions{
+		Pools:       map[string]string{"fast": "bulk"},
+		VolumePools: map[string]string{"fast/db": "ssd", "slow/logs": "archive"},
+	}
+	tests := []struct {
+		pool, volume, want string
+	}{
+		{"fast", "", "bulk"},        // root disk
+		{"fast", "data", "bulk"},    // volume without own mapping
+		{"fast", "db", "ssd"},       // VolumePools before Pools
+		{"slow", "logs", "archive"}, // VolumePools without Pools entry
+		{"slow", "", "slow"},        // unmapped
+	}
+	for _, tc := range tests {
+		if got := opts.pool(tc.pool, tc.volume); got != tc.want {
+			t.Errorf("pool(%q, %q) = %q, want %q", tc.pool, tc.volume, got, tc.want)
+		}
+	}
+}
+
+func TestRootDiskPool(t *testing.T) {
+	local := &api.Instance{}
+	local.Devices = map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}}
+	local.ExpandedDevices = map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "default"}}
+	if got := RootDiskPool(local); got != "fast" {
+		t.Errorf("local root disk: got %q", got)
+	}
+
+	inherited := &api.Instance{}
+	inherited.ExpandedDevices = map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "default"}}
+	if got := RootDiskPool(inherited); got != "default" {
+		t.Errorf("profile root disk: got %q", got)
+	}
+
+	if got := RootDiskPool(&api.Instance{}); got != "" {
+		t.Errorf("no root disk: got %q", got)
+	}
+}
+
+func TestInstanceForTarget(t *testing.T) {
+	opts := DeviceOptions{
+		Pools:        map[string]string{"fast": "bulk"},
+		VolumePools:  map[string]string{"fast/db": "ssd"},
+		VolumePrefix: "b-",
+	}
+
+	tests := []struct {
+		name       string
+		devices    map[string]map[string]string // local devices
+		expanded   map[string]map[string]string // devices incl. profiles
+		targetPool string
+		want       map[string]map[string]string
+	}{
+		{
+			name:     "local root disk",
+			devices:  map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}},
+			expanded: map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}},
+			want:     map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "bulk"}},
+		},
+		{
+			name:     "root disk from profile",
+			expanded: map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}},
+			want:     map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "bulk"}},
+		},
+		{
+			name:     "unmapped profile root disk is inherited",
+			expanded: map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "slow"}},
+			want:     map[string]map[string]string{},
+		},
+		{
+			name:       "explicit target pool",
+			devices:    map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}},
+			expanded:   map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "fast"}},
+			targetPool: "pinned",
+			want:       map[string]map[string]string{"root": {"type": "disk", "path": "/", "pool": "pinned"}},
+		},
+		{
+			name: "attached volumes",
+			devices: map[string]map[string]string{
+				"db":   {"type": "disk", "path": "/var/lib/db", "pool": "fast", "source": "db"},
+				"data": {"type": "disk", "path": "/srv", "pool": "fast", "source": "data"},
+			},
+			want: map[string]map[string]string{
+				"db":   {"type": "disk", "path": "/var/lib/db", "pool": "ssd", "source": "b-db"},
+				"data": {"type": "disk", "path": "/srv", "pool": "bulk", "source": "b-data"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := newFakeServer()
+			target.volumes["ssd/b-db"] = true
+			target.volumes["bulk/b-data"] = true
+
+			inst := &api.Instance{Name: "c1"}
+			inst.Devices = cloneDevices(tc.devices)
+			if inst.Devices == nil {
+				inst.Devices = map[string]map[string]string{}
+			}
+			inst.ExpandedDevices = tc.expanded
+			before := cloneDevices(inst.Devices)
+
+			got, dropped, err := instanceForTarget(target, inst, tc.targetPool, opts)
+			if err != nil {
+				t.Fatalf("instanceForTarget: %v", err)
+			}
+			if len(dropped) != 0 {
+				t.Fatalf("unexpected dropped devices: %v", dropped)
+			}
+			if !maps.EqualFunc(got.Devices, tc.want, maps.Equal) {
+				t.Fatalf("devices=%v want %v", got.Devices, tc.want)
+			}
+			if !maps.EqualFunc(inst.Devices, before, maps.Equal) {
+				t.Fatalf("source instance modified: %v", inst.Devices)
+			}
+		})
+	}
+}
+
+func TestInstanceForTarget_DropsMissing(t *testing.T) {
+	target := newFakeServer()
+	target.networks["lan"] = api.Network{Name: "lan", Managed: true}
+
+	inst := &api.Instance{Name: "c1"}
+	inst.Devices = map[string]map[string]string{
+		"eth0": {"type": "nic", "network": "lan"},
+		"eth1": {"type": "nic", "network": "dmz"},
+		"data": {"type": "disk", "path": "/srv", "pool": "fast", "source": "data"},
+		"gpu":  {"type": "gpu"},
+	}
+
+	got, dropped, err := instanceForTarget(target, inst, "", DeviceOptions{Exclude: []string{"gpu"}, Pools: map[string]string{"fast": "bulk"}})
+	if err != nil {
+		t.Fatalf("instanceForTarget: %v", err)
+	}
+	want := map[string]string{
+		"eth1": "target network dmz missing",
+		"data": "volume bulk/data missing on target host",
+		"gpu":  "excludeDevices config",
+	}
+	if !maps.Equal(dropped, want) {
+		t.Fatalf("dropped=%v want %v", dropped, want)
+	}
+	if len(got.Devices) != 1 || got.Devices["eth0"] == nil {
+		t.Fatalf("devices=%v", got.Devices)
+	}
+}
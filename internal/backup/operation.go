@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// waitOperation waits for op to finish. If ctx is done first, the operation is
+// cancelled on the server and the context error is returned.
+func waitOperation(ctx context.Context, op incus.Operation) (err error) {
+	ctx, span := startOperationSpan(ctx, op.Get())
+	defer func() { endSpan(span, err) }()
+
+	err = op.WaitContext(ctx)
+	if ctx.Err() == nil {
+		return err
+	}
+
+	cancelErr := op.Cancel()
+	if cancelErr != nil {
+		return errors.Join(ctx.Err(), fmt.Errorf("cancel operation failed: %w", cancelErr))
+	}
+	return ctx.Err()
+}
+
+// waitRemoteOperation is waitOperation for operations spanning source and target (copies).
+func waitRemoteOperation(ctx context.Context, op incus.RemoteOperation) (err error) {
+	target, _ := op.GetTarget()
+	var info api.Operation
+	if target != nil {
+		info = *target
+	}
+	ctx, span := startOperationSpan(ctx, info)
+	defer func() { endSpan(span, err) }()
+
+	done := make(chan error, 1)
+	go func() { done <- op.Wait() }()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// the waiting goroutine ends with the operation, done is buffered so it never blocks
+	cancelErr := op.CancelTarget()
+	if cancelErr != nil {
+		return errors.Join(ctx.Err(), fmt.Errorf("cancel operation failed: %w", cancelErr))
+	}
+	select {
+	case <-done:
+	case <-time.After(cancelDrainTimeout):
+	}
+	return ctx.Err()
+}
+
+// cancelDrainTimeout bounds the wait for a cancelled operation to end.
+const cancelDrainTimeout = 30 * time.Second
+
+// transferTracker records the bytes reported by the progress metadata of a migration.
+type transferTracker struct {
+	processed atomic.Int64
+}
+
+// trackTransfer follows the progress events of op. Incus reports the transferred
+// bytes as "processed" in the "progress" metadata, not every storage driver does.
+func trackTransfer(op incus.RemoteOperation) *transferTracker {
+	t := &transferTracker{}
+	_, _ = op.AddHandler(func(o api.Operation) {
+		progress, ok := o.Metadata["progress"].(map[string]any)
+		if !ok {
+			return
+		}
+		var n int64
+		switch v := progress["processed"].(type) {
+		case string:
+			n, _ = strconv.ParseInt(v, 10, 64)
+		case float64:
+			n = int64(v)
+		}
+		if n > t.processed.Load() {
+			t.processed.Store(n)
+		}
+	})
+	return t
+}
+
+// Bytes returns the highest number of transferred bytes seen, 0 if unknown.
+func (t *transferTracker) Bytes() int64 {
+	return t.processed.Load()
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// fakeRemoteOperation runs until it is cancelled.
+type fakeRemoteOperation struct {
+	incus.RemoteOperation
+
+	cancelled chan struct{}
+	waited    chan struct{} // closed when Wait returned
+}
+
+func (op *fakeRemoteOperation) GetTarget() (*api.Operation, error) {
+	return &api.Operation{ID: "op1", Description: "Migrating instance"}, nil
+}
+
+func (op *fakeRemoteOperation) Wait() error {
+	defer close(op.waited)
+	<-op.cancelled
+	return errors.New("operation cancelled")
+}
+
+func (op *fakeRemoteOperation) CancelTarget() error {
+	close(op.cancelled)
+	return nil
+}
+
+func TestWaitRemoteOperation_Cancel(t *testing.T) {
+	op := &fakeRemoteOperation{cancelled: make(chan struct{}), waited: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := waitRemoteOperation(ctx, op)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err=%v want context.Canceled", err)
+	}
+	select {
+	case <-op.waited:
+	case <-time.After(time.Second):
+		t.Fatal("operation was not cancelled and waited for")
+	}
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/rbnhln/incusAutobackup/internal/retention"
+)
+
+// The Preview functions compute what the corresponding backup step would do.
+// They only read from the servers.
+
+// CopyPreview describes the effect of CopyInstance or CopyVolume.
+type CopyPreview struct {
+	Refresh bool                         // the target already has the resource
+	Devices map[string]map[string]string // instances only: devices sent to the target
+	Dropped map[string]string            // instances only: dropped device → reason
+}
+
+// PreviewSnapshotInstance returns the source instance and whether it would be stopped for the snapshot.
+func PreviewSnapshotInstance(source incus.InstanceServer, instanceName string, stopIfRunning bool) (*api.Instance, bool, error) {
+	inst, _, err := source.GetInstance(instanceName)
+	if err != nil {
+		return nil, false, fmt.Errorf("get source instance %s failed: %w", instanceName, err)
+	}
+	if !stopIfRunning {
+		return inst, false, nil
+	}
+
+	state, _, err := source.GetInstanceState(instanceName)
+	if err != nil {
+		return nil, false, fmt.Errorf("get instance state %s failed: %w", instanceName, err)
+	}
+	return inst, state.Status == "Running", nil
+}
+
+// PreviewSnapshotVolume returns the source volume.
+func PreviewSnapshotVolume(source incus.InstanceServer, poolName, volumeName string) (*api.StorageVolume, error) {
+	vol, _, err := source.GetStoragePoolVolume(poolName, "custom", volumeName)
+	if err != nil {
+		return nil, fmt.Errorf("volume not found on source: %w", err)
+	}
+	return vol, nil
+}
+
+func PreviewCopyInstance(target incus.InstanceServer, instanceName, targetPool string, devices DeviceOptions, inst *api.Instance) (CopyPreview, error) {
+	instCopy, dropped, err := instanceForTarget(target, inst, targetPool, devices)
+	if err != nil {
+		return CopyPreview{}, err
+	}
+
+	_, _, err = target.GetInstance(instanceName)
+	if err != nil && !isNotFound(err) {
+		return CopyPreview{}, fmt.Errorf("get target instance %s failed: %w", instanceName, err)
+	}
+
+	return CopyPreview{Refresh: err == nil, Devices: instCopy.Devices, Dropped: dropped}, nil
+}
+
+func PreviewCopyVolume(target incus.InstanceServer, poolName, volumeName string) (CopyPreview, error) {
+	_, _, err := target.GetStoragePoolVolume(poolName, "custom", volumeName)
+	if err != nil && !isNotFound(err) {
+		return CopyPreview{}, fmt.Errorf("get target volume %s/%s failed: %w", poolName, volumeName, err)
+	}
+	return CopyPreview{Refresh: err == nil}, nil
+}
+
+// PreviewPruneInstance returns the prune plan for the instance. pending are snapshots
+// which the run creates or replicates before pruning.
+func PreviewPruneInstance(client incus.InstanceServer, instanceName, policy string, now time.Time, pending ...string) (retention.PrunePlan, error) {
+	return previewPrune(instanceSnapshotOps(context.Background(), client, instanceName), policy, now, pending)
+}
+
+// PreviewPruneVolume is PreviewPruneInstance for custom volumes.
+func PreviewPruneVolume(client incus.InstanceServer, poolName, volumeName, policy string, now time.Time, pending ...string) (retention.PrunePlan, error) {
+	return previewPrune(volumeSnapshotOps(context.Background(), client, poolName, volumeName), policy, now, pending)
+}
+
+func previewPrune(ops retention.SnapshotOps, policy string, now time.Time, pending []string) (retention.PrunePlan, error) {
+	names, err := ops.List()
+	if err != nil {
+		// a resource which is created by this run has no snapshots yet
+		if !isNotFound(err) || len(pending) == 0 {
+			return retention.PrunePlan{}, fmt.Errorf("list %s snapshots failed: %w", ops.Kind, err)
+		}
+	}
+	for _, p := range pending {
+		if p != "" && !slices.Contains(names, p) {
+			names = append(names, p)
+		}
+	}
+
+	return retention.BuildPrunePlan(names, strings.TrimSpace(policy), retention.PruneOptions{
+		Now:     now,
+		DryRun:  true,
+		Prefix:  retention.IABSnapshotPrefix,
+		ParseTS: retention.ParseIABSnapshotTime,
+	})
+}
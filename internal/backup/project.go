@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"strings"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// ProjectObjects selects the objects SyncProject replicates besides the project itself.
+type ProjectObjects struct {
+	Profiles    bool
+	Networks    bool
+	NetworkACLs bool
+
+	Devices DeviceOptions // adapts the devices of the profiles like those of the instances, Exclude is ignored
+}
+
+// SyncProject creates or updates targetProject and the selected objects on target from sourceProject on
+// source, so the target project is usable after a failover. Objects only present on target are kept.
+// It returns the changes, e.g. "create profile web". With dryRun the changes are only computed.
+func SyncProject(ctx context.Context, logger *slog.Logger, source, target incus.InstanceServer, sourceProject, targetProject string, objs ProjectObjects, dryRun bool) ([]string, error) {
+	s := &projectSync{logger: logger, dryRun: dryRun}
+
+	src, _, err := source.GetProject(sourceProject)
+	if err != nil {
+		return nil, fmt.Errorf("get source project %s failed: %w", sourceProject, err)
+	}
+	err = s.object(ctx, "project", targetProject,
+		func() (bool, string, error) {
+			cur, etag, err := target.GetProject(targetProject)
+			if err != nil {
+				return false, "", err
+			}
+			return reflect.DeepEqual(cur.ProjectPut, src.ProjectPut), etag, nil
+		},
+		func() error {
+			return target.CreateProject(api.ProjectsPost{Name: targetProject, ProjectPut: src.ProjectPut})
+		},
+		func(etag string) error { return target.UpdateProject(targetProject, src.ProjectPut, etag) },
+	)
+	if err != nil {
+		// without the project none of its objects can be created
+		return s.changes, err
+	}
+
+	source = source.UseProject(sourceProject)
+	target = target.UseProject(targetProject)
+	var errs []error
+
+	// projects without their own networks or profiles use those of the default project, which are left alone
+	networks := src.Config["features.networks"] == "true"
+	if (objs.Networks || objs.NetworkACLs) && !networks {
+		logger.Info("project uses the networks of the default project, skipping networks and network ACLs")
+	}
+
+	// ACLs first, networks reference them
+	if objs.NetworkACLs && networks {
+		acls, err := source.GetNetworkACLs()
+		if err != nil {
+			return s.changes, fmt.Errorf("get source network ACLs failed: %w", err)
+		}
+		for _, acl := range acls {
+			errs = append(errs, s.object(ctx, "network ACL", acl.Name,
+				func() (bool, string, error) {
+					cur, etag, err := target.GetNetworkACL(acl.Name)
+					if err != nil {
+						return false, "", err
+					}
+					return reflect.DeepEqual(cur.NetworkACLPut, acl.NetworkACLPut), etag, nil
+				},
+				func() error {
+					return target.CreateNetworkACL(api.NetworkACLsPost{NetworkACLPost: api.NetworkACLPost{Name: acl.Name}, NetworkACLPut: acl.NetworkACLPut})
+				},
+				func(etag string) error { return target.UpdateNetworkACL(acl.Name, acl.NetworkACLPut, etag) },
+			))
+		}
+	}
+
+	synced := make(map[string]bool) // networks the profiles may use, also if only created by a dry run
+	if objs.Networks && networks {
+		nets, err := source.GetNetworks()
+		if err != nil {
+			return s.changes, fmt.Errorf("get source networks failed: %w", err)
+		}
+		for _, n := range nets {
+			if !n.Managed {
+				continue
+			}
+			synced[n.Name] = true
+			put := n.NetworkPut
+			put.Config = withoutVolatile(n.Config)
+			errs = append(errs, s.object(ctx, "network", n.Name,
+				func() (bool, string, error) {
+					cur, etag, err := target.GetNetwork(n.Name)
+					if err != nil {
+						return false, "", err
+					}
+					return cur.Description == put.Description && maps.Equal(withoutVolatile(cur.Config), put.Config), etag, nil
+				},
+				func() error {
+					return target.CreateNetwork(api.NetworksPost{Name: n.Name, Type: n.Type, NetworkPut: put})
+				},
+				func(etag string) error { return target.UpdateNetwork(n.Name, put, etag) },
+			))
+		}
+	}
+
+	if objs.Profiles && src.Config["features.profiles"] == "true" {
+		profiles, err := source.GetProfiles()
+		if err != nil {
+			return s.changes, fmt.Errorf("get source profiles failed: %w", err)
+		}
+		for _, p := range profiles {
+			put, dropped, err := profileForTarget(target, p.ProfilePut, objs.Devices, synced)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", p.Name, err))
+				continue
+			}
+			for devName, reason := range dropped {
+				logger.Warn("dropping profile device", "profile", p.Name, "device", devName, "reason", reason)
+			}
+			errs = append(errs, s.object(ctx, "profile", p.Name,
+				func() (bool, string, error) {
+					cur, etag, err := target.GetProfile(p.Name)
+					if err != nil {
+						return false, "", err
+					}
+					return reflect.DeepEqual(cur.ProfilePut, put), etag, nil
+				},
+				func() error { return target.CreateProfile(api.ProfilesPost{Name: p.Name, ProfilePut: put}) },
+				func(etag string) error { return target.UpdateProfile(p.Name, put, etag) },
+			))
+		}
+	} else if objs.Profiles {
+		logger.Info("project uses the profiles of the default project, skipping profiles")
+	}
+
+	return s.changes, errors.Join(errs...)
+}
+
+type projectSync struct {
+	logger  *slog.Logger
+	dryRun  bool
+	changes []string
+}
+
+// object creates an object missing on target or updates it if current reports a difference.
+func (s *projectSync) object(ctx context.Context, kind, name string, current func() (equal bool, etag string, err error), create func() error, update func(etag string) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	equal, etag, err := current()
+	missing := api.StatusErrorCheck(err, http.StatusNotFound)
+	if err != nil && !missing {
+		return fmt.Errorf("get target %s %s failed: %w", kind, name, err)
+	}
+
+	action, apply := "update", func() error { return update(etag) }
+	switch {
+	case missing:
+		action, apply = "create", create
+	case equal:
+		return nil
+	}
+	s.changes = append(s.changes, action+" "+kind+" "+name)
+	if s.dryRun {
+		return nil
+	}
+
+	s.logger.Info("Replicating project object", "action", action, "kind", kind, "name", name)
+	err = apply()
+	if err != nil {
+		return fmt.Errorf("%s %s %s failed: %w", action, kind, name, err)
+	}
+	return nil
+}
+
+// withoutVolatile returns config without the volatile.* keys, which are managed by each server.
+func withoutVolatile(config map[string]string) map[string]string {
+	out := make(map[string]string, len(config))
+	for k, v := range config {
+		if !strings.HasPrefix(k, "volatile.") {
+			out[k] = v
+		}
+	}
+	return out
+}
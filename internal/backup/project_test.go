@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// sourceProject returns a source with a project using its own networks and profiles.
+func sourceProject() *fakeServer {
+	src := newFakeServer()
+	src.projects["web"] = api.ProjectPut{Config: map[string]string{"features.networks": "true", "features.profiles": "true"}}
+	src.acls["allow-http"] = api.NetworkACLPut{Description: "http"}
+	src.networks["lan"] = api.Network{Name: "lan", Type: "bridge", Managed: true, NetworkPut: api.NetworkPut{
+		Config: map[string]string{"ipv4.address": "10.0.0.1/24", "volatile.bridge.hwaddr": "00:16:3e:00:00:01"},
+	}}
+	src.networks["eth0"] = api.Network{Name: "eth0", Type: "physical"} // unmanaged
+	src.profiles["default"] = api.ProfilePut{Devices: map[string]map[string]string{
+		"root": {"type": "disk", "path": "/", "pool": "fast"},
+		"data": {"type": "disk", "path": "/srv", "pool": "fast", "source": "data"},
+		"eth0": {"type": "nic", "network": "lan"},
+	}}
+	return src
+}
+
+func TestSyncProject_Create(t *testing.T) {
+	src, dst := sourceProject(), newFakeServer()
+	objs := ProjectObjects{
+		Profiles:    true,
+		Networks:    true,
+		NetworkACLs: true,
+		Devices:     DeviceOptions{Pools: map[string]string{"fast": "bulk"}, VolumePrefix: "b-"},
+	}
+
+	changes, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "site-a", objs, false)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	want := []string{"create project site-a", "create network ACL allow-http", "create network lan", "create profile default"}
+	if !slices.Equal(changes, want) || !slices.Equal(dst.writes, want) {
+		t.Fatalf("changes=%q writes=%q want %q", changes, dst.writes, want)
+	}
+
+	if _, ok := dst.networks["lan"].Config["volatile.bridge.hwaddr"]; ok {
+		t.Fatal("volatile network config was copied")
+	}
+	devices := dst.profiles["default"].Devices
+	if devices["root"]["pool"] != "bulk" || devices["data"]["pool"] != "bulk" || devices["data"]["source"] != "b-data" {
+		t.Fatalf("profile disks not mapped: %v", devices)
+	}
+	if devices["eth0"] == nil {
+		t.Fatal("nic of a synced network was dropped")
+	}
+	if src.profiles["default"].Devices["root"]["pool"] != "fast" {
+		t.Fatal("source profile was modified")
+	}
+}
+
+func TestSyncProject_Update(t *testing.T) {
+	src, dst := sourceProject(), newFakeServer()
+	objs := ProjectObjects{Profiles: true, Networks: true, NetworkACLs: true}
+	_, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "web", objs, false)
+	if err != nil {
+		t.Fatalf("initial sync: %v", err)
+	}
+
+	// only changed objects are updated, a dry run reports without writing
+	dst.writes = nil
+	src.acls["allow-http"] = api.NetworkACLPut{Description: "http and https"}
+	changes, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "web", objs, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !slices.Equal(changes, []string{"update network ACL allow-http"}) || len(dst.writes) != 0 {
+		t.Fatalf("dry run: changes=%q writes=%q", changes, dst.writes)
+	}
+
+	changes, err = SyncProject(context.Background(), discardLogger, src, dst, "web", "web", objs, false)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if !slices.Equal(changes, []string{"update network ACL allow-http"}) || dst.acls["allow-http"].Description != "http and https" {
+		t.Fatalf("changes=%q acl=%+v", changes, dst.acls["allow-http"])
+	}
+}
+
+func TestSyncProject_SharedNetworksAndProfiles(t *testing.T) {
+	src, dst := sourceProject(), newFakeServer()
+	src.projects["web"] = api.ProjectPut{Config: map[string]string{}}
+
+	changes, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "web", ProjectObjects{Profiles: true, Networks: true, NetworkACLs: true}, false)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if !slices.Equal(changes, []string{"create project web"}) {
+		t.Fatalf("objects of the default project were synced: %q", changes)
+	}
+}
+
+func TestSyncProject_Errors(t *testing.T) {
+	t.Run("source project", func(t *testing.T) {
+		src := sourceProject()
+		src.fail["GetProject"] = errors.New("forbidden")
+		changes, err := SyncProject(context.Background(), discardLogger, src, newFakeServer(), "web", "web", ProjectObjects{Profiles: true}, false)
+		if err == nil || len(changes) != 0 {
+			t.Fatalf("changes=%q err=%v", changes, err)
+		}
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		src, dst := sourceProject(), newFakeServer()
+		src.profiles["web"] = api.ProfilePut{Description: "web"}
+		dst.fail["CreateProfile"] = errors.New("pool fast not found")
+
+		changes, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "web", ProjectObjects{Profiles: true, Networks: true}, false)
+		if err == nil || !strings.Contains(err.Error(), "create profile default failed: pool fast not found") {
+			t.Fatalf("err=%v", err)
+		}
+		if !slices.Contains(changes, "create network lan") || !slices.Contains(changes, "create profile web") {
+			t.Fatalf("one failing profile stopped the sync: %q", changes)
+		}
+	})
+
+	t.Run("missing network", func(t *testing.T) {
+		src, dst := sourceProject(), newFakeServer()
+		_, err := SyncProject(context.Background(), discardLogger, src, dst, "web", "web", ProjectObjects{Profiles: true}, false)
+		if err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		if _, ok := dst.profiles["default"].Devices["eth0"]; ok {
+			t.Fatal("nic of a network missing on the target was kept")
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := SyncProject(ctx, discardLogger, sourceProject(), newFakeServer(), "web", "web", ProjectObjects{Profiles: true}, false)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err=%v want context.Canceled", err)
+		}
+	})
+}
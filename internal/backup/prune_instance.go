@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -10,50 +11,22 @@ import (
 	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
-func PruneInstance(logger *slog.Logger, source, target incus.InstanceServer, instanceName, sourcePolicy, targetPolicy string, now time.Time, pruneDryRun bool) error {
-	err := pruneInstanceSnapshots(logger, "source", source, instanceName, sourcePolicy, now, pruneDryRun)
+// PruneInstance removes the IAB snapshots not kept by policy and returns the prune plan.
+func PruneInstance(ctx context.Context, logger *slog.Logger, role string, client incus.InstanceServer, instanceName, policy string, now time.Time, pruneDryRun bool) (retention.PrunePlan, error) {
+	plan, err := pruneInstanceSnapshots(ctx, logger, role, client, instanceName, policy, now, pruneDryRun)
 	if err != nil {
-		return fmt.Errorf("source prune failed: %w", err)
+		return plan, fmt.Errorf("%s prune failed: %w", role, err)
 	}
-
-	err = pruneInstanceSnapshots(logger, "target", target, instanceName, targetPolicy, now, pruneDryRun)
-	if err != nil {
-		return fmt.Errorf("target prune failed: %w", err)
-	}
-	return nil
+	return plan, nil
 }
 
-func pruneInstanceSnapshots(logger *slog.Logger, role string, client incus.InstanceServer, instanceName, policy string, now time.Time, pruneDryRun bool) error {
+func pruneInstanceSnapshots(ctx context.Context, logger *slog.Logger, role string, client incus.InstanceServer, instanceName, policy string, now time.Time, pruneDryRun bool) (retention.PrunePlan, error) {
 	if strings.TrimSpace(policy) == "" {
 		logger.Info("retention disabled; keeping all IAB snapshots", "role", role, "kind", "instance", "instance", instanceName)
-		return nil
+		return retention.PrunePlan{}, nil
 	}
 
-	ops := retention.SnapshotOps{
-		Kind: "instance",
-		List: func() ([]string, error) {
-			names, err := client.GetInstanceSnapshotNames(instanceName)
-			if err != nil {
-				return nil, err
-			}
-
-			normalized := make([]string, 0, len(names))
-			for _, n := range names {
-				if i := strings.LastIndex(n, "/"); i >= 0 && i < len(n)-1 {
-					n = n[i+1:]
-				}
-				normalized = append(normalized, n)
-			}
-			return normalized, nil
-		},
-		Delete: func(name string) error {
-			op, err := client.DeleteInstanceSnapshot(instanceName, name)
-			if err != nil {
-				return err
-			}
-			return op.Wait()
-		},
-	}
+	ops := instanceSnapshotOps(ctx, client, instanceName)
 
 	plan, err := retention.PruneSnapshots(ops, policy, retention.PruneOptions{
 		Now:     now,
@@ -62,7 +35,7 @@ func pruneInstanceSnapshots(logger *slog.Logger, role string, client incus.Insta
 		ParseTS: retention.ParseIABSnapshotTime,
 	})
 	if err != nil {
-		return err
+		return retention.PrunePlan{}, err
 	}
 
 	if len(plan.Future) > 0 {
@@ -92,5 +65,39 @@ func pruneInstanceSnapshots(logger *slog.Logger, role string, client incus.Insta
 			"unmanaged", len(plan.Unmanaged),
 		)
 	}
-	return nil
+	return plan, nil
+}
+
+// instanceSnapshotOps lists and deletes the snapshots of a instance, deletions stop once ctx is done.
+func instanceSnapshotOps(ctx context.Context, client incus.InstanceServer, instanceName string) retention.SnapshotOps {
+	return retention.SnapshotOps{
+		Kind: "instance",
+		List: func() ([]string, error) {
+			names, err := client.GetInstanceSnapshotNames(instanceName)
+			if err != nil {
+				return nil, err
+			}
+
+			normalized := make([]string, 0, len(names))
+			for _, n := range names {
+				if i := strings.LastIndex(n, "/"); i >= 0 && i < len(n)-1 {
+					n = n[i+1:]
+				}
+				normalized = append(normalized, n)
+			}
+			return normalized, nil
+		},
+		Delete: func(name string) error {
+			// do not start further deletions once the run is aborted
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			op, err := client.DeleteInstanceSnapshot(instanceName, name)
+			if err != nil {
+				return err
+			}
+			return waitOperation(ctx, op)
+		},
+	}
 }
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -10,19 +11,17 @@ import (
 	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
-func PruneVolume(logger *slog.Logger, source, target incus.InstanceServer, poolName, volumeName, sourcePolicy, targetPolicy string, now time.Time, pruneDryRune bool) error {
-	err := pruneVolumeSnapshots(logger, "source", source, poolName, volumeName, sourcePolicy, now, pruneDryRune)
+// PruneVolume removes the IAB snapshots not kept by policy and returns the prune plan.
+func PruneVolume(ctx context.Context, logger *slog.Logger, role string, client incus.InstanceServer, poolName, volumeName, policy string, now time.Time, pruneDryRune bool) (retention.PrunePlan, error) {
+	plan, err := pruneVolumeSnapshots(ctx, logger, role, client, poolName, volumeName, policy, now, pruneDryRune)
 	if err != nil {
-		return fmt.Errorf("source prune failed: %w", err)
+		return plan, fmt.Errorf("%s prune failed: %w", role, err)
 	}
-	err = pruneVolumeSnapshots(logger, "target", target, poolName, volumeName, targetPolicy, now, pruneDryRune)
-	if err != nil {
-		return fmt.Errorf("target prune failed: %w", err)
-	}
-	return nil
+	return plan, nil
 }
 
 func pruneVolumeSnapshots(
+	ctx context.Context,
 	logger *slog.Logger,
 	role string,
 	client incus.InstanceServer,
@@ -31,7 +30,7 @@ func pruneVolumeSnapshots(
 	policy string,
 	now time.Time,
 	pruneDryRun bool,
-) error {
+) (retention.PrunePlan, error) {
 	if strings.TrimSpace(policy) == "" {
 		logger.Info("retention disabled; keeping all IAB snapshots",
 			"role", role,
@@ -39,36 +38,10 @@ func pruneVolumeSnapshots(
 			"pool", poolName,
 			"volume", volumeName,
 		)
-		return nil
+		return retention.PrunePlan{}, nil
 	}
 
-	ops := retention.SnapshotOps{
-		Kind: "volume",
-		List: func() ([]string, error) {
-			snaps, err := client.GetStoragePoolVolumeSnapshots(poolName, "custom", volumeName)
-			if err != nil {
-				return nil, err
-			}
-
-			names := make([]string, 0, len(snaps))
-			for _, s := range snaps {
-				// Je nach Endpoint kann Name "volume/snap" sein
-				n := s.Name
-				if i := strings.LastIndex(n, "/"); i >= 0 && i < len(n)-1 {
-					n = n[i+1:]
-				}
-				names = append(names, n)
-			}
-			return names, nil
-		},
-		Delete: func(name string) error {
-			op, err := client.DeleteStoragePoolVolumeSnapshot(poolName, "custom", volumeName, name)
-			if err != nil {
-				return err
-			}
-			return op.Wait()
-		},
-	}
+	ops := volumeSnapshotOps(ctx, client, poolName, volumeName)
 
 	plan, err := retention.PruneSnapshots(ops, policy, retention.PruneOptions{
 		Now:     now,
@@ -77,7 +50,7 @@ func pruneVolumeSnapshots(
 		ParseTS: retention.ParseIABSnapshotTime,
 	})
 	if err != nil {
-		return err
+		return retention.PrunePlan{}, err
 	}
 
 	if len(plan.Future) > 0 {
@@ -113,5 +86,41 @@ func pruneVolumeSnapshots(
 		)
 	}
 
-	return nil
+	return plan, nil
+}
+
+// volumeSnapshotOps lists and deletes the snapshots of a custom volume, deletions stop once ctx is done.
+func volumeSnapshotOps(ctx context.Context, client incus.InstanceServer, poolName, volumeName string) retention.SnapshotOps {
+	return retention.SnapshotOps{
+		Kind: "volume",
+		List: func() ([]string, error) {
+			snaps, err := client.GetStoragePoolVolumeSnapshots(poolName, "custom", volumeName)
+			if err != nil {
+				return nil, err
+			}
+
+			names := make([]string, 0, len(snaps))
+			for _, s := range snaps {
+				// Je nach Endpoint kann Name "volume/snap" sein
+				n := s.Name
+				if i := strings.LastIndex(n, "/"); i >= 0 && i < len(n)-1 {
+					n = n[i+1:]
+				}
+				names = append(names, n)
+			}
+			return names, nil
+		},
+		Delete: func(name string) error {
+			// do not start further deletions once the run is aborted
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+			op, err := client.DeleteStoragePoolVolumeSnapshot(poolName, "custom", volumeName, name)
+			if err != nil {
+				return err
+			}
+			return waitOperation(ctx, op)
+		},
+	}
 }
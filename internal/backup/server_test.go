@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeServer keeps the objects of one Incus project in memory. Methods not needed by the tests panic.
+type fakeServer struct {
+	incus.InstanceServer
+
+	projects map[string]api.ProjectPut
+	acls     map[string]api.NetworkACLPut
+	networks map[string]api.Network
+	profiles map[string]api.ProfilePut
+	volumes  map[string]bool // "pool/name" of custom volumes
+
+	fail   map[string]error // method name -> error
+	writes []string         // "create profile web", ...
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		projects: make(map[string]api.ProjectPut),
+		acls:     make(map[string]api.NetworkACLPut),
+		networks: make(map[string]api.Network),
+		profiles: make(map[string]api.ProfilePut),
+		volumes:  make(map[string]bool),
+		fail:     make(map[string]error),
+	}
+}
+
+var errNotFound = api.StatusErrorf(http.StatusNotFound, "not found")
+
+func (s *fakeServer) UseProject(name string) incus.InstanceServer { return s }
+
+func (s *fakeServer) GetProject(name string) (*api.Project, string, error) {
+	if err := s.fail["GetProject"]; err != nil {
+		return nil, "", err
+	}
+	put, ok := s.projects[name]
+	if !ok {
+		return nil, "", errNotFound
+	}
+	return &api.Project{Name: name, ProjectPut: put}, "etag", nil
+}
+
+func (s *fakeServer) CreateProject(p api.ProjectsPost) error {
+	s.writes = append(s.writes, "create project "+p.Name)
+	s.projects[p.Name] = p.ProjectPut
+	return nil
+}
+
+func (s *fakeServer) UpdateProject(name string, put api.ProjectPut, etag string) error {
+	s.writes = append(s.writes, "update project "+name)
+	s.projects[name] = put
+	return nil
+}
+
+func (s *fakeServer) GetNetworkACLs() ([]api.NetworkACL, error) {
+	var out []api.NetworkACL
+	for _, name := range slices.Sorted(maps.Keys(s.acls)) {
+		out = append(out, api.NetworkACL{NetworkACLPost: api.NetworkACLPost{Name: name}, NetworkACLPut: s.acls[name]})
+	}
+	return out, nil
+}
+
+func (s *fakeServer) GetNetworkACL(name string) (*api.NetworkACL, string, error) {
+	put, ok := s.acls[name]
+	if !ok {
+		return nil, "", errNotFound
+	}
+	return &api.NetworkACL{NetworkACLPost: api.NetworkACLPost{Name: name}, NetworkACLPut: put}, "etag", nil
+}
+
+func (s *fakeServer) CreateNetworkACL(acl api.NetworkACLsPost) error {
+	s.writes = append(s.writes, "create network ACL "+acl.Name)
+	s.acls[acl.Name] = acl.NetworkACLPut
+	return nil
+}
+
+func (s *fakeServer) UpdateNetworkACL(name string, put api.NetworkACLPut, etag string) error {
+	s.writes = append(s.writes, "update network ACL "+name)
+	s.acls[name] = put
+	return nil
+}
+
+func (s *fakeServer) GetNetworks() ([]api.Network, error) {
+	return slicesOf(s.networks), nil
+}
+
+func (s *fakeServer) GetNetwork(name string) (*api.Network, string, error) {
+	if err := s.fail["GetNetwork"]; err != nil {
+		return nil, "", err
+	}
+	n, ok := s.networks[name]
+	if !ok {
+		return nil, "", errNotFound
+	}
+	return &n, "etag", nil
+}
+
+func (s *fakeServer) CreateNetwork(n api.NetworksPost) error {
+	s.writes = append(s.writes, "create network "+n.Name)
+	s.networks[n.Name] = api.Network{Name: n.Name, Type: n.Type, Managed: true, NetworkPut: n.NetworkPut}
+	return nil
+}
+
+func (s *fakeServer) UpdateNetwork(name string, put api.NetworkPut, etag string) error {
+	s.writes = append(s.writes, "update network "+name)
+	n := s.networks[name]
+	n.NetworkPut = put
+	s.networks[name] = n
+	return nil
+}
+
+func (s *fakeServer) GetProfiles() ([]api.Profile, error) {
+	if err := s.fail["GetProfiles"]; err != nil {
+		return nil, err
+	}
+	var out []api.Profile
+	for _, name := range slices.Sorted(maps.Keys(s.profiles)) {
+		out = append(out, api.Profile{Name: name, ProfilePut: s.profiles[name]})
+	}
+	return out, nil
+}
+
+func (s *fakeServer) GetProfile(name string) (*api.Profile, string, error) {
+	put, ok := s.profiles[name]
+	if !ok {
+		return nil, "", errNotFound
+	}
+	return &api.Profile{Name: name, ProfilePut: put}, "etag", nil
+}
+
+func (s *fakeServer) CreateProfile(p api.ProfilesPost) error {
+	if err := s.fail["CreateProfile"]; err != nil {
+		return err
+	}
+	s.writes = append(s.writes, "create profile "+p.Name)
+	s.profiles[p.Name] = p.ProfilePut
+	return nil
+}
+
+func (s *fakeServer) UpdateProfile(name string, put api.ProfilePut, etag string) error {
+	s.writes = append(s.writes, "update profile "+name)
+	s.profiles[name] = put
+	return nil
+}
+
+func (s *fakeServer) GetStoragePoolVolume(pool, volType, name string) (*api.StorageVolume, string, error) {
+	if !s.volumes[pool+"/"+name] {
+		return nil, "", errNotFound
+	}
+	return &api.StorageVolume{Name: name, Type: volType}, "etag", nil
+}
+
+func slicesOf[V any](m map[string]V) []V {
+	var out []V
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		out = append(out, m[k])
+	}
+	return out
+}
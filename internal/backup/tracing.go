@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/lxc/incus/v6/shared/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/rbnhln/incusAutobackup/internal/backup")
+
+// startOperationSpan starts a span covering the wait for an Incus operation,
+// named after the operation description (e.g. "Snapshotting instance").
+func startOperationSpan(ctx context.Context, op api.Operation) (context.Context, trace.Span) {
+	name := op.Description
+	if name == "" {
+		name = "incus operation"
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("incus.operation.id", op.ID),
+		attribute.String("incus.operation.class", op.Class),
+	))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
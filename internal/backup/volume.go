@@ -1,24 +1,33 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"time"
 
 	incus "github.com/lxc/incus/v6/client"
 	"github.com/lxc/incus/v6/shared/api"
+	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
-func SnapshotVolume(logger *slog.Logger, source incus.InstanceServer, poolName, volumeName string) (*api.StorageVolume, error) {
+// SnapshotVolume creates an IAB snapshot of the custom volume and returns the volume and the snapshot name.
+func SnapshotVolume(ctx context.Context, logger *slog.Logger, source incus.InstanceServer, poolName, volumeName string) (*api.StorageVolume, string, error) {
 	logger = logger.With("volume", volumeName)
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
 	// 1. Check if Volume exists on Source pool
 	incusVolume, _, err := source.GetStoragePoolVolume(poolName, "custom", volumeName)
 	if err != nil {
-		return nil, fmt.Errorf("volume not found on source: %w", err)
+		return nil, "", fmt.Errorf("volume not found on source: %w", err)
 	}
 
 	// 2. Create Snapshot
-	snapshotName := fmt.Sprintf("IAB_%s", time.Now().Format("20060102-150405"))
+	snapshotName := retention.IABSnapshotName(time.Now())
 	logger.Info("Creating snapshot", "snapshot", snapshotName)
 
 	req := api.StorageVolumeSnapshotsPost{
@@ -27,19 +36,27 @@ func SnapshotVolume(logger *slog.Logger, source incus.InstanceServer, poolName,
 
 	op, err := source.CreateStoragePoolVolumeSnapshot(poolName, "custom", volumeName, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+		return nil, "", fmt.Errorf("failed to create snapshot: %w", err)
 	}
-	err = op.Wait()
+	err = waitOperation(ctx, op)
 	if err != nil {
-		return nil, fmt.Errorf("snapshot operation failed: %w", err)
+		return nil, "", fmt.Errorf("snapshot operation failed: %w", err)
 	}
-	return incusVolume, nil
+	return incusVolume, snapshotName, nil
 }
 
-func CopyVolume(logger *slog.Logger, source, target incus.InstanceServer, poolName, volumeName, projectMode string, incusVolume *api.StorageVolume) error {
+// CopyVolume refreshes the custom volume of sourcePool on targetPool of target and returns the
+// transferred bytes, 0 if unknown.
+func CopyVolume(ctx context.Context, logger *slog.Logger, source, target incus.InstanceServer, sourcePool, targetPool, volumeName, projectMode string, incusVolume *api.StorageVolume) (int64, error) {
 	logger = logger.With("volume", volumeName)
 	// 3. Copy to target
 	logger.Info("Copying volume to target")
+
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
 	copyArgs := incus.StoragePoolVolumeCopyArgs{
 		Name:       volumeName,
 		Mode:       projectMode,
@@ -48,15 +65,16 @@ func CopyVolume(logger *slog.Logger, source, target incus.InstanceServer, poolNa
 	}
 
 	// the copy operation
-	opCopy, err := target.CopyStoragePoolVolume(poolName, source, poolName, *incusVolume, &copyArgs)
+	opCopy, err := target.CopyStoragePoolVolume(targetPool, source, sourcePool, *incusVolume, &copyArgs)
 	if err != nil {
-		return fmt.Errorf("failed to start copy operation: %w", err)
+		return 0, fmt.Errorf("failed to start copy operation: %w", err)
 	}
+	transfer := trackTransfer(opCopy)
 
-	if err := opCopy.Wait(); err != nil {
-		return fmt.Errorf("copy operation failed: %w", err)
+	if err := waitRemoteOperation(ctx, opCopy); err != nil {
+		return 0, fmt.Errorf("copy operation failed: %w", err)
 	}
 
-	logger.Info("Volume sync successful")
-	return nil
+	logger.Info("Volume sync successful", "bytes", transfer.Bytes())
+	return transfer.Bytes(), nil
 }
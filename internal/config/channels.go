@@ -0,0 +1,81 @@
+package config
+
+import "fmt"
+
+// ChannelByName returns the channel with the given name.
+func (c Config) ChannelByName(name string) (Channel, bool) {
+	for _, ch := range c.IAB.Channels {
+		if ch.Name == name {
+			return ch, true
+		}
+	}
+	return Channel{}, false
+}
+
+// ResolveNotify returns the channels notified about a resource of a project backed up from the
+// source host. The notify list of an instance or volume replaces the one of its project.
+// Several sources may have a project of the same name, an empty source matches any of them.
+func (c Config) ResolveNotify(source, project string, kind RetentionKind, name string) []string {
+	for _, p := range c.Projects {
+		if p.Name != project {
+			continue
+		}
+		if h, err := c.ProjectSource(p); source != "" && (err != nil || h.Name != source) {
+			continue
+		}
+		switch kind {
+		case RetentionInstances:
+			for _, inst := range p.Instances {
+				if inst.Name == name && len(inst.Notify) > 0 {
+					return inst.Notify
+				}
+			}
+		case RetentionVolumes:
+			for _, vol := range p.Volumes {
+				if vol.Name == name && len(vol.Notify) > 0 {
+					return vol.Notify
+				}
+			}
+		}
+		return p.Notify
+	}
+	return nil
+}
+
+func (c Config) validateChannels() []error {
+	var errs []error
+
+	seen := make(map[string]bool)
+	for i, ch := range c.IAB.Channels {
+		field := fmt.Sprintf("iab.channels[%d]", i)
+		if ch.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", field))
+		} else if seen[ch.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate channel %q", field, ch.Name))
+		}
+		seen[ch.Name] = true
+		if ch.Empty() {
+			errs = append(errs, fmt.Errorf("%s: no notifier configured", field))
+		}
+		errs = append(errs, ch.Notifiers.validate(field)...)
+	}
+
+	check := func(field string, names []string) {
+		for _, name := range names {
+			if !seen[name] || name == "" {
+				errs = append(errs, fmt.Errorf("%s.notify: unknown channel %q", field, name))
+			}
+		}
+	}
+	for _, p := range c.Projects {
+		check("projects."+p.Name, p.Notify)
+		for _, inst := range p.Instances {
+			check("projects."+p.Name+".instances."+inst.Name, inst.Notify)
+		}
+		for _, vol := range p.Volumes {
+			check("projects."+p.Name+".volumes."+vol.Name, vol.Notify)
+		}
+	}
+
+	return errs
+}
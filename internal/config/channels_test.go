@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestResolveNotify(t *testing.T) {
+	cfg := Config{Projects: []Project{
+		{
+			Name:      "db",
+			Notify:    []string{"dba"},
+			Instances: []Instance{{Name: "pg"}, {Name: "mysql", Notify: []string{"legacy"}}},
+			Volumes:   []Volume{{Name: "dumps", Notify: []string{"dba", "storage"}}},
+		},
+		{Name: "web", Instances: []Instance{{Name: "nginx"}}},
+	}}
+
+	tests := []struct {
+		project string
+		kind    RetentionKind
+		name    string
+		want    []string
+	}{
+		{"db", RetentionInstances, "pg", []string{"dba"}},
+		{"db", RetentionInstances, "mysql", []string{"legacy"}},
+		{"db", RetentionVolumes, "dumps", []string{"dba", "storage"}},
+		{"db", RetentionVolumes, "other", []string{"dba"}},
+		{"web", RetentionInstances, "nginx", nil},
+		{"unknown", RetentionInstances, "pg", nil},
+	}
+	for _, tc := range tests {
+		got := cfg.ResolveNotify("", tc.project, tc.kind, tc.name)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s/%s/%s: got %v, want %v", tc.project, tc.kind, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResolveNotify_SourcesSharingAProject(t *testing.T) {
+	cfg := Config{
+		Hosts: []Host{{Name: "site-a", Role: "source"}, {Name: "site-b", Role: "source"}, {Name: "nas", Role: "target"}},
+		Projects: []Project{
+			{Name: "default", Source: "site-a", Notify: []string{"team-a"}},
+			{Name: "default", Source: "site-b", Notify: []string{"team-b"}, Instances: []Instance{{Name: "pg", Notify: []string{"dba"}}}},
+		},
+	}
+
+	tests := []struct {
+		source string
+		kind   RetentionKind
+		name   string
+		want   []string
+	}{
+		{"site-a", RetentionInstances, "pg", []string{"team-a"}},
+		{"site-b", RetentionInstances, "pg", []string{"dba"}},
+		{"site-b", RetentionInstances, "web", []string{"team-b"}},
+		{"site-b", "", "", []string{"team-b"}},
+		{"nas", RetentionInstances, "pg", nil},
+	}
+	for _, tc := range tests {
+		got := cfg.ResolveNotify(tc.source, "default", tc.kind, tc.name)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s/%s/%s: got %v, want %v", tc.source, tc.kind, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	cfg := Config{
+		IAB: IAB{Channels: []Channel{
+			{Name: "dba", Notifiers: Notifiers{GotifyURL: "https://gotify.example.org/message?token=x"}},
+			{Name: "dba", Notifiers: Notifiers{Ntfy: Ntfy{URL: "https://ntfy.sh/"}}},
+			{Name: "empty"},
+		}},
+		Projects: []Project{{Name: "db", Notify: []string{"dba", "nope"}}},
+	}
+
+	var got []string
+	for _, err := range cfg.validateChannels() {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`iab.channels[1].name: duplicate channel "dba"`,
+		`iab.channels[1].ntfy.url: expected an http(s) topic URL, got "https://ntfy.sh/"`,
+		`iab.channels[2]: no notifier configured`,
+		`projects.db.notify: unknown channel "nope"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("errors:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestValidateNotifierPriorities(t *testing.T) {
+	zero, six, eleven := 0, 6, 11
+	n := Notifiers{
+		GotifyURL: "https://gotify.example.org/message?token=x",
+		Gotify:    Gotify{SuccessPriority: &zero, FailurePriority: &eleven},
+		Ntfy:      Ntfy{URL: "ftp://ntfy.sh/backups", SuccessPriority: &zero, FailurePriority: &six},
+		Webhooks:  []Webhook{{Name: "slack", URL: "hooks.slack.com"}},
+		Email:     Email{Host: "mail.example.org", TLS: EmailTLSNone, Username: "iab", From: "iab@example.org", To: []string{"ops@example.org"}},
+	}
+
+	var got []string
+	for _, err := range n.validate("iab") {
+		got = append(got, err.Error())
+	}
+	slices.Sort(got) // priorities are checked in map order
+	want := []string{
+		`iab.email.username: authentication with tls none is only possible on localhost`,
+		`iab.gotify.failurePriority: must be between 0 and 10`,
+		`iab.ntfy.failurePriority: must be between 1 and 5`,
+		`iab.ntfy.successPriority: must be between 1 and 5`,
+		`iab.ntfy.url: expected an http(s) URL, got "ftp://ntfy.sh/backups"`,
+		`iab.webhooks[0].url: expected an http(s) URL, got "hooks.slack.com"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("errors:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestChannelJSON(t *testing.T) {
+	var iab IAB
+	err := json.Unmarshal([]byte(`{"gotifyUrl": "https://g/", "channels": [{"name": "dba", "gotifyUrl": "https://dba/", "gotify": {"notifyOnSuccess": true}}]}`), &iab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iab.GotifyURL != "https://g/" || len(iab.Channels) != 1 {
+		t.Fatalf("unexpected iab: %+v", iab)
+	}
+	ch := iab.Channels[0]
+	if ch.Name != "dba" || ch.GotifyURL != "https://dba/" || !ch.Gotify.NotifyOnSuccess {
+		t.Fatalf("unexpected channel: %+v", ch)
+	}
+}
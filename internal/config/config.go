@@ -4,46 +4,390 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
 type IAB struct {
-	IABCredDir      string `json:"iabCredDir"`
-	UUID            string `json:"uuid"`
-	StopInstance    bool   `json:"stopInstance,omitempty"`
-	HealthchecksURL string `json:"healthchecksUrl,omitempty"`
-	GotifyURL       string `json:"gotifyUrl,omitempty"`
-	DryRunCopy      bool   `json:"-"`
-	DryRunPrune     bool   `json:"-"`
-	IncusOSfix      bool   `json:"-"`
+	IABCredDir   string `json:"iabCredDir"`
+	UUID         string `json:"uuid"`
+	StopInstance bool   `json:"stopInstance,omitempty"`
+	Notifiers
+	Channels      []Channel         `json:"channels,omitempty"`
+	PoolMapping   map[string]string `json:"poolMapping,omitempty"` // source pool -> pool on the targets
+	Notifications Delivery          `json:"notifications,omitempty"`
+	Concurrency   Concurrency       `json:"concurrency,omitempty"`
+	Timeouts      Timeouts          `json:"timeouts,omitempty"`
+	Retry         Retry             `json:"retry,omitempty"`
+	Lock          Lock              `json:"lock,omitempty"`
+	ReportFile    string            `json:"reportFile,omitempty"`
+	HistoryFile   string            `json:"historyFile,omitempty"`
+	Metrics       Metrics           `json:"metrics,omitempty"`
+	Tracing       Tracing           `json:"tracing,omitempty"`
+	Log           Log               `json:"log,omitempty"`
+	DryRunCopy    bool              `json:"-"`
+	DryRunPrune   bool              `json:"-"`
+	IncusOSfix    bool              `json:"-"`
+	ReportFormat  string            `json:"-"`
 }
 
+// Notifiers are the notification targets of the run (iab) or of a channel.
+type Notifiers struct {
+	HealthchecksURL string    `json:"healthchecksUrl,omitempty"`
+	GotifyURL       string    `json:"gotifyUrl,omitempty"`
+	Gotify          Gotify    `json:"gotify,omitempty"`
+	Ntfy            Ntfy      `json:"ntfy,omitempty"`
+	Email           Email     `json:"email,omitempty"`
+	Webhooks        []Webhook `json:"webhooks,omitempty"`
+}
+
+// Empty reports whether no notifier is configured.
+func (n Notifiers) Empty() bool {
+	return n.HealthchecksURL == "" && n.GotifyURL == "" && n.Ntfy.URL == "" && n.Email.Host == "" && len(n.Webhooks) == 0
+}
+
+// Channel is a named set of notifiers that projects, instances and volumes reference in notify.
+// A channel is only told about the tasks of the resources routed to it.
+type Channel struct {
+	Name string `json:"name"`
+	Notifiers
+}
+
+// Gotify configures the messages sent to gotifyUrl. Unset priorities use the notifier defaults.
+type Gotify struct {
+	NotifyOnSuccess bool `json:"notifyOnSuccess,omitempty"`
+	SuccessPriority *int `json:"successPriority,omitempty"`
+	FailurePriority *int `json:"failurePriority,omitempty"`
+}
+
+// Ntfy publishes the run summary to an ntfy topic, e.g. "https://ntfy.sh/my-backups".
+// Priorities range from 1 (min) to 5 (max), unset priorities use the notifier defaults.
+type Ntfy struct {
+	URL             string   `json:"url,omitempty"`
+	Token           string   `json:"token,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	NotifyOnSuccess bool     `json:"notifyOnSuccess,omitempty"`
+	SuccessPriority *int     `json:"successPriority,omitempty"`
+	FailurePriority *int     `json:"failurePriority,omitempty"`
+}
+
+// TLS modes of Email.
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+// Email sends the run summary via SMTP. Recipients lists per outcome (success, failed, aborted)
+// take precedence over To, an outcome without recipients is not mailed.
+type Email struct {
+	Host       string              `json:"host,omitempty"`
+	Port       int                 `json:"port,omitempty"`
+	TLS        string              `json:"tls,omitempty"` // starttls (default), tls or none (no username except on localhost)
+	Username   string              `json:"username,omitempty"`
+	Password   string              `json:"password,omitempty"`
+	From       string              `json:"from,omitempty"`
+	To         []string            `json:"to,omitempty"`
+	Recipients map[string][]string `json:"recipients,omitempty"`
+}
+
+// TLSMode returns the configured TLS mode, starttls if unset.
+func (e Email) TLSMode() string {
+	if e.TLS == "" {
+		return EmailTLSStartTLS
+	}
+	return strings.ToLower(e.TLS)
+}
+
+// SMTPPort returns the configured port or the default of the TLS mode.
+func (e Email) SMTPPort() int {
+	switch {
+	case e.Port != 0:
+		return e.Port
+	case e.TLSMode() == EmailTLSImplicit:
+		return 465
+	default:
+		return 587
+	}
+}
+
+// RecipientsFor returns the recipients of a run with the given outcome.
+func (e Email) RecipientsFor(outcome string) []string {
+	if to, ok := e.Recipients[outcome]; ok {
+		return to
+	}
+	return e.To
+}
+
+var emailOutcomes = []string{"success", "failed", "aborted"}
+
+// Defaults of Delivery, used when the values are zero.
+const (
+	DefaultDeliveryAttempts   = 3
+	DefaultDeliveryBackoff    = 5 * time.Second
+	DefaultDeliveryMaxBackoff = time.Minute
+	DefaultSpoolDir           = "iab-spool"
+)
+
+// Delivery configures retries of notifications. Notifications still undelivered after the
+// last attempt are stored in SpoolDir and sent again at the start of the next run.
+type Delivery struct {
+	Attempts   int    `json:"attempts,omitempty"`
+	Backoff    string `json:"backoff,omitempty"`
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+	SpoolDir   string `json:"spoolDir,omitempty"`
+}
+
+// AttemptCount returns the configured attempts, DefaultDeliveryAttempts if unset.
+func (d Delivery) AttemptCount() int {
+	if d.Attempts == 0 {
+		return DefaultDeliveryAttempts
+	}
+	return d.Attempts
+}
+
+// Backoffs returns the delay before the first retry and the upper bound of the doubling delays.
+func (d Delivery) Backoffs() (backoff, maxBackoff time.Duration, err error) {
+	backoff, err = ParseDuration(d.Backoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxBackoff, err = ParseDuration(d.MaxBackoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	if d.Backoff == "" {
+		backoff = DefaultDeliveryBackoff
+	}
+	if d.MaxBackoff == "" {
+		maxBackoff = DefaultDeliveryMaxBackoff
+	}
+	return backoff, max(backoff, maxBackoff), nil
+}
+
+// SpoolPath returns the configured spool directory or DefaultSpoolDir.
+func (d Delivery) SpoolPath() string {
+	if strings.TrimSpace(d.SpoolDir) == "" {
+		return DefaultSpoolDir
+	}
+	return d.SpoolDir
+}
+
+// WebhookEvents are the events a webhook can subscribe to.
+var WebhookEvents = []string{"start", "finish", "taskFailed"}
+
+// Webhook sends a request rendered from a Go text/template for run events.
+// Template and TemplateFile are exclusive, without both a generic JSON payload is sent.
+type Webhook struct {
+	Name         string            `json:"name"`
+	URL          string            `json:"url"`
+	Method       string            `json:"method,omitempty"` // default POST
+	Headers      map[string]string `json:"headers,omitempty"`
+	ContentType  string            `json:"contentType,omitempty"` // default application/json
+	Template     string            `json:"template,omitempty"`
+	TemplateFile string            `json:"templateFile,omitempty"`
+	Events       []string          `json:"events,omitempty"` // default finish
+}
+
+// HTTPMethod returns the configured method, POST if unset.
+func (w Webhook) HTTPMethod() string {
+	if w.Method == "" {
+		return "POST"
+	}
+	return strings.ToUpper(w.Method)
+}
+
+// PayloadContentType returns the configured content type, application/json if unset.
+func (w Webhook) PayloadContentType() string {
+	if w.ContentType == "" {
+		return "application/json"
+	}
+	return w.ContentType
+}
+
+// EventList returns the subscribed events, finish if unset.
+func (w Webhook) EventList() []string {
+	if len(w.Events) == 0 {
+		return []string{"finish"}
+	}
+	return w.Events
+}
+
+// Concurrency limits how many tasks of a phase run in parallel.
+// Pools are keyed by "host/pool". Zero values mean sequential (tasks) or unlimited (perHost, perPool).
+type Concurrency struct {
+	Tasks   int            `json:"tasks,omitempty"`
+	PerHost int            `json:"perHost,omitempty"`
+	PerPool int            `json:"perPool,omitempty"`
+	Hosts   map[string]int `json:"hosts,omitempty"`
+	Pools   map[string]int `json:"pools,omitempty"`
+}
+
+// Timeouts bound a single attempt of a task, per task kind. Values are Go durations (e.g. "30m"), empty means no timeout.
+type Timeouts struct {
+	Snapshot string `json:"snapshot,omitempty"`
+	Copy     string `json:"copy,omitempty"`
+	Prune    string `json:"prune,omitempty"`
+}
+
+// Retry configures how failed tasks are attempted again.
+// RetryOn lists error classes: network, timeout, server (5xx), client (4xx), other.
+type Retry struct {
+	Attempts   int      `json:"attempts,omitempty"`
+	Backoff    string   `json:"backoff,omitempty"`
+	MaxBackoff string   `json:"maxBackoff,omitempty"`
+	RetryOn    []string `json:"retryOn,omitempty"`
+}
+
+// DefaultLockFile is used when Lock.File is empty, relative to the working directory.
+const DefaultLockFile = "iab.lock"
+
+// DefaultLockTTL is used when Lock.TTL is empty.
+const DefaultLockTTL = 24 * time.Hour
+
+// Lock prevents overlapping runs. The local lockfile is always used,
+// Server additionally locks every source and target host of the run via its "user.iab.lock" config key.
+// The server lock is renewed while the run is active, TTL only matters for runners that died holding it.
+type Lock struct {
+	File   string `json:"file,omitempty"`
+	Server bool   `json:"server,omitempty"`
+	TTL    string `json:"ttl,omitempty"`
+}
+
+// FilePath returns the configured lockfile or DefaultLockFile.
+func (l Lock) FilePath() string {
+	if strings.TrimSpace(l.File) == "" {
+		return DefaultLockFile
+	}
+	return l.File
+}
+
+// TTLDuration returns the expiry of the server lock, DefaultLockTTL if unset.
+func (l Lock) TTLDuration() (time.Duration, error) {
+	d, err := ParseDuration(l.TTL)
+	if err != nil || d > 0 {
+		return d, err
+	}
+	return DefaultLockTTL, nil
+}
+
+// DefaultMetricsJob is the Pushgateway job name used when Metrics.Job is empty.
+const DefaultMetricsJob = "iab"
+
+// Metrics exports Prometheus metrics at the end of every run, as a node_exporter textfile and/or to a Pushgateway.
+type Metrics struct {
+	Textfile       string `json:"textfile,omitempty"`
+	PushgatewayURL string `json:"pushgatewayUrl,omitempty"`
+	Job            string `json:"job,omitempty"`
+}
+
+// JobName returns the configured Pushgateway job or DefaultMetricsJob.
+func (m Metrics) JobName() string {
+	if strings.TrimSpace(m.Job) == "" {
+		return DefaultMetricsJob
+	}
+	return m.Job
+}
+
+// Tracing exports OpenTelemetry spans of every run via OTLP/HTTP. Empty Endpoint disables tracing.
+type Tracing struct {
+	Endpoint string            `json:"endpoint,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// Defaults of Log, used when the values are unset.
+const (
+	DefaultLogMaxSizeMB  = 10
+	DefaultLogMaxBackups = 3
+)
+
+// Log configures the log output of a run. Command line flags take precedence.
+// With File set, logs are written to the file instead of stdout and rotated by size.
+type Log struct {
+	Format     string `json:"format,omitempty"` // text or json
+	Level      string `json:"level,omitempty"`
+	Source     bool   `json:"source,omitempty"` // add the source file and line to every record
+	File       string `json:"file,omitempty"`
+	MaxSizeMB  int    `json:"maxSizeMb,omitempty"`
+	MaxBackups *int   `json:"maxBackups,omitempty"` // nil: DefaultLogMaxBackups, 0: no backups
+}
+
+// MaxSizeBytes returns the rotation size of the log file.
+func (l Log) MaxSizeBytes() int64 {
+	if l.MaxSizeMB == 0 {
+		return DefaultLogMaxSizeMB << 20
+	}
+	return int64(l.MaxSizeMB) << 20
+}
+
+// Backups returns how many rotated log files are kept.
+func (l Log) Backups() int {
+	if l.MaxBackups == nil {
+		return DefaultLogMaxBackups
+	}
+	return *l.MaxBackups
+}
+
+var logFormats = []string{"text", "json"}
+var logLevels = []string{"debug", "info", "warn", "warning", "error"}
+
+var retryClasses = []string{"network", "timeout", "server", "client", "other"}
+
 type Host struct {
 	Name string `json:"name"`
 	Role string `json:"role"`
 	URL  string `json:"url"`
+	From string `json:"from,omitempty"`
 }
 
 type Instance struct {
 	Name           string   `json:"name"`
 	Storage        string   `json:"storage"`
 	ExcludeDevices []string `json:"excludeDevices,omitempty"`
+	Notify         []string `json:"notify,omitempty"`
+
+	// read from the user.iab.* keys of the instance during planning
+	Keys    InstanceKeys `json:"-"`
+	KeysErr error        `json:"-"` // invalid keys fail the tasks of this instance only
+
+	RootPool string `json:"-"` // pool of the root disk on the source, read during planning
 }
 
 type Volume struct {
-	Name    string `json:"name"`
-	Storage string `json:"storage"`
+	Name          string   `json:"name"`
+	Storage       string   `json:"storage"`
+	TargetStorage string   `json:"targetStorage,omitempty"` // pool on the targets, default: iab.poolMapping or storage
+	Notify        []string `json:"notify,omitempty"`
 }
 
 type Project struct {
 	Name        string     `json:"name"`
 	Description string     `json:"description,omitempty"`
+	Source      string     `json:"source,omitempty"`
 	Mode        string     `json:"mode,omitempty"`
 	Instances   []Instance `json:"instances,omitempty"`
 	Volumes     []Volume   `json:"volumes,omitempty"`
+	Discover    Discovery  `json:"discover,omitempty"`
+	Notify      []string   `json:"notify,omitempty"`
+
+	// naming on the targets, sources keep the configured names
+	TargetProject string `json:"targetProject,omitempty"` // default: name
+	TargetPrefix  string `json:"targetPrefix,omitempty"`  // prepended to instance and volume names
+	TargetSuffix  string `json:"targetSuffix,omitempty"`  // appended to instance and volume names
+
+	// All backs up every instance and custom volume and replicates the project with its profiles.
+	All         bool `json:"all,omitempty"`
+	Networks    bool `json:"networks,omitempty"`    // with all: replicate the managed networks of the project
+	NetworkACLs bool `json:"networkAcls,omitempty"` // with all: replicate the network ACLs of the project
+
+	// set during planning if the resources of the project could not be discovered, fails a plan task of the project
+	DiscoverErr error `json:"-"`
 }
 
 type RetentionGroup struct {
@@ -92,6 +436,13 @@ func Load(path string) (*Config, error) {
 		}
 
 	}
+
+	// unnamed hosts are addressed by their role
+	for i := range cfg.Hosts {
+		if strings.TrimSpace(cfg.Hosts[i].Name) == "" {
+			cfg.Hosts[i].Name = cfg.Hosts[i].Role
+		}
+	}
 	return cfg, nil
 }
 
@@ -104,6 +455,33 @@ func Write(path string, cfg Config) error {
 	return os.WriteFile(path, b, 0o600)
 }
 
+// HostsByRole returns all configured hosts with the given role, in config order.
+func (c Config) HostsByRole(role string) []Host {
+	var hosts []Host
+	for _, h := range c.Hosts {
+		if h.Role == role {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// ParseDuration parses an optional duration setting, an empty string is zero.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
+	}
+	return d, nil
+}
+
 func (c *Config) Validate() error {
 	var errs []error
 
@@ -111,6 +489,95 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Errorf("iab.iabCredDir must not be empty"))
 	}
 
+	if len(c.HostsByRole("source")) == 0 {
+		errs = append(errs, fmt.Errorf("hosts: at least one host with role 'source' is required"))
+	}
+	if len(c.HostsByRole("target")) == 0 {
+		errs = append(errs, fmt.Errorf("hosts: at least one host with role 'target' is required"))
+	}
+	seenHosts := make(map[string]struct{}, len(c.Hosts))
+	for _, h := range c.Hosts {
+		if _, ok := seenHosts[h.Name]; ok {
+			errs = append(errs, fmt.Errorf("hosts: duplicate host name %q (hosts sharing a role need distinct names)", h.Name))
+		}
+		seenHosts[h.Name] = struct{}{}
+	}
+	errs = append(errs, c.validateTopology()...)
+	errs = append(errs, c.validateChannels()...)
+	errs = append(errs, c.validateDiscovery()...)
+	errs = append(errs, c.validateNaming()...)
+	errs = append(errs, c.validatePools()...)
+
+	cc := c.IAB.Concurrency
+	if cc.Tasks < 0 || cc.PerHost < 0 || cc.PerPool < 0 {
+		errs = append(errs, fmt.Errorf("iab.concurrency: limits must not be negative"))
+	}
+	for name, n := range cc.Hosts {
+		if _, ok := c.HostByName(name); !ok {
+			errs = append(errs, fmt.Errorf("iab.concurrency.hosts.%s: unknown host", name))
+		}
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("iab.concurrency.hosts.%s: limit must not be negative", name))
+		}
+	}
+	for key, n := range cc.Pools {
+		if !strings.Contains(key, "/") {
+			errs = append(errs, fmt.Errorf("iab.concurrency.pools.%s: expected key in the form host/pool", key))
+		}
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("iab.concurrency.pools.%s: limit must not be negative", key))
+		}
+	}
+
+	for field, d := range map[string]string{
+		"iab.timeouts.snapshot":        c.IAB.Timeouts.Snapshot,
+		"iab.timeouts.copy":            c.IAB.Timeouts.Copy,
+		"iab.timeouts.prune":           c.IAB.Timeouts.Prune,
+		"iab.retry.backoff":            c.IAB.Retry.Backoff,
+		"iab.retry.maxBackoff":         c.IAB.Retry.MaxBackoff,
+		"iab.lock.ttl":                 c.IAB.Lock.TTL,
+		"iab.notifications.backoff":    c.IAB.Notifications.Backoff,
+		"iab.notifications.maxBackoff": c.IAB.Notifications.MaxBackoff,
+	} {
+		_, err := ParseDuration(d)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	if c.IAB.Lock.Server && strings.TrimSpace(c.IAB.UUID) == "" {
+		errs = append(errs, fmt.Errorf("iab.lock.server: requires iab.uuid to identify the lock owner"))
+	}
+	if u := c.IAB.Metrics.PushgatewayURL; u != "" {
+		errs = append(errs, validateHTTPURL("iab.metrics.pushgatewayUrl", u)...)
+	}
+	if u := c.IAB.Tracing.Endpoint; u != "" {
+		errs = append(errs, validateHTTPURL("iab.tracing.endpoint", u)...)
+	}
+	errs = append(errs, c.IAB.Notifiers.validate("iab")...)
+	if f := c.IAB.Log.Format; f != "" && !slices.Contains(logFormats, f) {
+		errs = append(errs, fmt.Errorf("iab.log.format: unknown format %q (expected text or json)", f))
+	}
+	if l := strings.ToLower(c.IAB.Log.Level); l != "" && !slices.Contains(logLevels, l) {
+		errs = append(errs, fmt.Errorf("iab.log.level: unknown level %q (expected debug, info, warn or error)", c.IAB.Log.Level))
+	}
+	if c.IAB.Log.MaxSizeMB < 0 || c.IAB.Log.Backups() < 0 {
+		errs = append(errs, fmt.Errorf("iab.log: maxSizeMb and maxBackups must not be negative"))
+	}
+	if strings.HasSuffix(c.IAB.Metrics.Textfile, "/") {
+		errs = append(errs, fmt.Errorf("iab.metrics.textfile: must be a file, not a directory"))
+	}
+	if c.IAB.Notifications.Attempts < 0 {
+		errs = append(errs, fmt.Errorf("iab.notifications.attempts: must not be negative"))
+	}
+	if c.IAB.Retry.Attempts < 0 {
+		errs = append(errs, fmt.Errorf("iab.retry.attempts: must not be negative"))
+	}
+	for _, class := range c.IAB.Retry.RetryOn {
+		if !slices.Contains(retryClasses, class) {
+			errs = append(errs, fmt.Errorf("iab.retry.retryOn: unknown error class %q (expected one of %s)", class, strings.Join(retryClasses, ", ")))
+		}
+	}
+
 	for role, hr := range c.Retention.Hosts {
 		if hr.Default != "" {
 			_, err := retention.ParseSchedule(hr.Default)
@@ -160,26 +627,26 @@ func (c *Config) Validate() error {
 
 	for _, p := range c.Projects {
 		for _, vol := range p.Volumes {
-			for _, role := range []string{"source", "target"} {
-				pol := c.ResolveRetention(role, p.Name, RetentionVolumes, vol.Name)
+			for _, host := range c.Hosts {
+				pol := c.ResolveRetention(host, p.Name, RetentionVolumes, vol.Name)
 				if pol == "" {
 					continue
 				}
 				_, err := retention.ParseSchedule(pol)
 				if err != nil {
-					errs = append(errs, fmt.Errorf("resolved retention (%s/%s volume %s): %w", role, p.Name, vol.Name, err))
+					errs = append(errs, fmt.Errorf("resolved retention (%s/%s volume %s): %w", host.Name, p.Name, vol.Name, err))
 				}
 			}
 		}
 		for _, inst := range p.Instances {
-			for _, role := range []string{"source", "target"} {
-				pol := c.ResolveRetention(role, p.Name, RetentionInstances, inst.Name)
+			for _, host := range c.Hosts {
+				pol := c.ResolveRetention(host, p.Name, RetentionInstances, inst.Name)
 				if pol == "" {
 					continue
 				}
 				_, err := retention.ParseSchedule(pol)
 				if err != nil {
-					errs = append(errs, fmt.Errorf("resolved retention (%s/%s instance %s): %w", role, p.Name, inst.Name, err))
+					errs = append(errs, fmt.Errorf("resolved retention (%s/%s instance %s): %w", host.Name, p.Name, inst.Name, err))
 				}
 			}
 		}
@@ -190,3 +657,94 @@ func (c *Config) Validate() error {
 	}
 	return nil
 }
+
+// validateHTTPURL checks that value is an absolute http(s) URL.
+func validateHTTPURL(field, value string) []error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return []error{fmt.Errorf("%s: expected an http(s) URL, got %q", field, value)}
+	}
+	return nil
+}
+
+// validate checks the notifier settings below prefix, e.g. "iab" or "iab.channels[0]".
+func (n Notifiers) validate(prefix string) []error {
+	var errs []error
+	for field, p := range map[string]*int{
+		prefix + ".gotify.successPriority": n.Gotify.SuccessPriority,
+		prefix + ".gotify.failurePriority": n.Gotify.FailurePriority,
+	} {
+		if p != nil && (*p < 0 || *p > 10) {
+			errs = append(errs, fmt.Errorf("%s: must be between 0 and 10", field))
+		}
+	}
+	if u := n.Ntfy.URL; u != "" {
+		urlErrs := validateHTTPURL(prefix+".ntfy.url", u)
+		if parsed, _ := url.Parse(u); len(urlErrs) == 0 && strings.Trim(parsed.Path, "/") == "" {
+			urlErrs = append(urlErrs, fmt.Errorf("%s.ntfy.url: expected an http(s) topic URL, got %q", prefix, u))
+		}
+		errs = append(errs, urlErrs...)
+	}
+	for field, p := range map[string]*int{
+		prefix + ".ntfy.successPriority": n.Ntfy.SuccessPriority,
+		prefix + ".ntfy.failurePriority": n.Ntfy.FailurePriority,
+	} {
+		if p != nil && (*p < 1 || *p > 5) {
+			errs = append(errs, fmt.Errorf("%s: must be between 1 and 5", field))
+		}
+	}
+	if em := n.Email; em.Host != "" {
+		if !slices.Contains([]string{EmailTLSStartTLS, EmailTLSImplicit, EmailTLSNone}, em.TLSMode()) {
+			errs = append(errs, fmt.Errorf("%s.email.tls: unknown mode %q (expected starttls, tls or none)", prefix, em.TLS))
+		}
+		if em.Port < 0 || em.Port > 65535 {
+			errs = append(errs, fmt.Errorf("%s.email.port: invalid port %d", prefix, em.Port))
+		}
+		// net/smtp refuses to send the password over an unencrypted connection to another host
+		if em.TLSMode() == EmailTLSNone && em.Username != "" && !slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, em.Host) {
+			errs = append(errs, fmt.Errorf("%s.email.username: authentication with tls none is only possible on localhost", prefix))
+		}
+		_, err := mail.ParseAddress(em.From)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.email.from: %w", prefix, err))
+		}
+		for outcome := range em.Recipients {
+			if !slices.Contains(emailOutcomes, outcome) {
+				errs = append(errs, fmt.Errorf("%s.email.recipients.%s: unknown outcome (expected success, failed or aborted)", prefix, outcome))
+			}
+		}
+		checked := make(map[string]bool)
+		for _, outcome := range emailOutcomes {
+			for _, rcpt := range em.RecipientsFor(outcome) {
+				if checked[rcpt] {
+					continue
+				}
+				checked[rcpt] = true
+				_, err := mail.ParseAddress(rcpt)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s.email: invalid recipient %q: %w", prefix, rcpt, err))
+				}
+			}
+		}
+	}
+	seenHooks := make(map[string]bool)
+	for i, wh := range n.Webhooks {
+		field := fmt.Sprintf("%s.webhooks[%d]", prefix, i)
+		if wh.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", field))
+		} else if seenHooks[wh.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate webhook %q", field, wh.Name))
+		}
+		seenHooks[wh.Name] = true
+		errs = append(errs, validateHTTPURL(field+".url", wh.URL)...)
+		if wh.Template != "" && wh.TemplateFile != "" {
+			errs = append(errs, fmt.Errorf("%s: template and templateFile are exclusive", field))
+		}
+		for _, ev := range wh.Events {
+			if !slices.Contains(WebhookEvents, ev) {
+				errs = append(errs, fmt.Errorf("%s.events: unknown event %q (expected one of %s)", field, ev, strings.Join(WebhookEvents, ", ")))
+			}
+		}
+	}
+	return errs
+}
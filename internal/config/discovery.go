@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// Discovery selects instances and volumes of a project on its source at run start, in addition to
+// the listed ones.
+type Discovery struct {
+	Instances Selector `json:"instances,omitempty"`
+	Volumes   Selector `json:"volumes,omitempty"`
+}
+
+// Selector picks resources by name and config keys. Every criterion which is set has to match,
+// all: true selects every resource. Resources matching exclude are never selected.
+type Selector struct {
+	All     bool              `json:"all,omitempty"`
+	Config  map[string]string `json:"config,omitempty"` // config keys and their value, e.g. "user.iab.backup": "true"
+	Names   []string          `json:"names,omitempty"`  // glob patterns, one of them has to match
+	Regex   string            `json:"regex,omitempty"`
+	Exclude []string          `json:"exclude,omitempty"` // glob patterns
+
+	// volumes only
+	Pools []string `json:"pools,omitempty"` // restrict to these pools, default all
+
+	// instances only, as in projects[].instances
+	Storage        string   `json:"storage,omitempty"`
+	ExcludeDevices []string `json:"excludeDevices,omitempty"`
+
+	regex *regexp.Regexp // Regex, compiled by Config.Validate
+}
+
+// Enabled reports whether the selector selects anything.
+func (s Selector) Enabled() bool {
+	return s.All || len(s.Config) > 0 || len(s.Names) > 0 || s.Regex != ""
+}
+
+// Match reports whether a resource with the given name and config is selected.
+// The selector has to be valid, Config.Validate compiles its regex.
+func (s Selector) Match(name string, config map[string]string) bool {
+	if !s.Enabled() || matchAny(s.Exclude, name) {
+		return false
+	}
+	for k, v := range s.Config {
+		if config[k] != v {
+			return false
+		}
+	}
+	if len(s.Names) > 0 && !matchAny(s.Names, name) {
+		return false
+	}
+	if s.Regex != "" {
+		re := s.regex
+		if re == nil {
+			re = regexp.MustCompile(s.Regex) // not validated
+		}
+		if !re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Selector) validate(field string) []error {
+	var errs []error
+	for _, p := range append(append([]string(nil), s.Names...), s.Exclude...) {
+		_, err := path.Match(p, "")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid pattern %q: %w", field, p, err))
+		}
+	}
+	if s.Regex != "" {
+		re, err := regexp.Compile(s.Regex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.regex: %w", field, err))
+		}
+		s.regex = re
+	}
+	return errs
+}
+
+func (c *Config) validateDiscovery() []error {
+	var errs []error
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		if (p.Networks || p.NetworkACLs) && !p.All {
+			errs = append(errs, fmt.Errorf("projects.%s: networks and networkAcls require all", p.Name))
+		}
+		field := "projects." + p.Name + ".discover"
+		errs = append(errs, p.Discover.Instances.validate(field+".instances")...)
+		errs = append(errs, p.Discover.Volumes.validate(field+".volumes")...)
+		if len(p.Discover.Instances.Pools) > 0 {
+			errs = append(errs, fmt.Errorf("%s.instances.pools: only supported for volumes", field))
+		}
+		if p.Discover.Volumes.Storage != "" || len(p.Discover.Volumes.ExcludeDevices) > 0 {
+			errs = append(errs, fmt.Errorf("%s.volumes: storage and excludeDevices are only supported for instances", field))
+		}
+	}
+	return errs
+}
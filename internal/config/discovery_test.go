@@ -0,0 +1,67 @@
+package config
+
+import "testing"
+
+func TestSelectorMatch(t *testing.T) {
+	backup := map[string]string{"user.iab.backup": "true"}
+
+	tests := []struct {
+		name   string
+		sel    Selector
+		res    string
+		config map[string]string
+		want   bool
+	}{
+		{"disabled", Selector{}, "c1", nil, false},
+		{"all", Selector{All: true}, "c1", nil, true},
+		{"all with exclude", Selector{All: true, Exclude: []string{"tmp-*"}}, "tmp-1", nil, false},
+		{"config key", Selector{Config: backup}, "c1", backup, true},
+		{"config key missing", Selector{Config: backup}, "c1", map[string]string{"user.iab.backup": "false"}, false},
+		{"glob", Selector{Names: []string{"db-*", "web-*"}}, "web-1", nil, true},
+		{"glob mismatch", Selector{Names: []string{"db-*"}}, "web-1", nil, false},
+		{"regex", Selector{Regex: `^pg\d+$`}, "pg12", nil, true},
+		{"regex mismatch", Selector{Regex: `^pg\d+$`}, "pgadmin", nil, false},
+		{"all criteria", Selector{Config: backup, Names: []string{"db-*"}}, "db-1", nil, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if errs := tc.sel.validate("sel"); len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if got := tc.sel.Match(tc.res, tc.config); got != tc.want {
+				t.Fatalf("Match(%q)=%v want %v", tc.res, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateDiscovery(t *testing.T) {
+	cfg := Config{Projects: []Project{{
+		Name: "web",
+		Discover: Discovery{
+			Instances: Selector{Names: []string{"web-["}, Pools: []string{"local"}},
+			Volumes:   Selector{Regex: "(", Storage: "local"},
+		},
+	}}}
+
+	if errs := cfg.validateDiscovery(); len(errs) != 4 {
+		t.Fatalf("errors=%d want 4: %v", len(errs), errs)
+	}
+
+	valid := Config{Projects: []Project{{Name: "db", Discover: Discovery{Instances: Selector{Regex: `^pg\d+$`}}}}}
+	if errs := valid.validateDiscovery(); len(errs) != 0 || valid.Projects[0].Discover.Instances.regex == nil {
+		t.Fatalf("regex not compiled by validation: %v", errs)
+	}
+}
+
+func TestValidateDiscovery_NetworksRequireAll(t *testing.T) {
+	cfg := Config{Projects: []Project{
+		{Name: "web", Networks: true},
+		{Name: "db", All: true, Networks: true, NetworkACLs: true},
+	}}
+
+	errs := cfg.validateDiscovery()
+	if len(errs) != 1 || errs[0].Error() != "projects.web: networks and networkAcls require all" {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
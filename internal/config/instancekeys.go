@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rbnhln/incusAutobackup/internal/retention"
+)
+
+// Incus config keys of an instance controlling its backup.
+const (
+	KeyRetentionSource = "user.iab.retention.source"
+	KeyRetentionTarget = "user.iab.retention.target"
+	KeyTargetPool      = "user.iab.target-pool"
+	KeyExcludeDevices  = "user.iab.exclude-devices" // comma separated device names
+	KeyStop            = "user.iab.stop"
+)
+
+// InstanceKeys are the backup settings read from the user.iab.* keys of an instance.
+// They take precedence over the settings of the instance in the IAB config.
+type InstanceKeys struct {
+	Retention      map[string]string // policy by host role
+	TargetPool     string
+	ExcludeDevices []string
+	Stop           *bool
+}
+
+// ParseInstanceKeys reads the user.iab.* keys from the config of an instance.
+// Retention policies are checked with retention.ParseSchedule.
+func ParseInstanceKeys(cfg map[string]string) (InstanceKeys, error) {
+	var k InstanceKeys
+	for role, key := range map[string]string{"source": KeyRetentionSource, "target": KeyRetentionTarget} {
+		pol := strings.TrimSpace(cfg[key])
+		if pol == "" {
+			continue
+		}
+		_, err := retention.ParseSchedule(pol)
+		if err != nil {
+			return InstanceKeys{}, fmt.Errorf("%s: %w", key, err)
+		}
+		if k.Retention == nil {
+			k.Retention = make(map[string]string)
+		}
+		k.Retention[role] = pol
+	}
+
+	k.TargetPool = strings.TrimSpace(cfg[KeyTargetPool])
+	for _, dev := range strings.Split(cfg[KeyExcludeDevices], ",") {
+		if dev = strings.TrimSpace(dev); dev != "" {
+			k.ExcludeDevices = append(k.ExcludeDevices, dev)
+		}
+	}
+
+	if v := strings.TrimSpace(cfg[KeyStop]); v != "" {
+		stop, err := strconv.ParseBool(v)
+		if err != nil {
+			return InstanceKeys{}, fmt.Errorf("%s: expected true or false, got %q", KeyStop, v)
+		}
+		k.Stop = &stop
+	}
+	return k, nil
+}
+
+// TargetPool returns the pool of the root disk on the target, empty to keep the pool of the source.
+func (i Instance) TargetPool() string {
+	if i.Keys.TargetPool != "" {
+		return i.Keys.TargetPool
+	}
+	return i.Storage
+}
+
+// DevicesToExclude returns the devices dropped during copy.
+func (i Instance) DevicesToExclude() []string {
+	devices := append([]string(nil), i.ExcludeDevices...)
+	for _, dev := range i.Keys.ExcludeDevices {
+		if !slices.Contains(devices, dev) {
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// StopInstance reports whether the instance is stopped for its snapshot, global is iab.stopInstance.
+func (i Instance) StopInstance(global bool) bool {
+	if i.Keys.Stop != nil {
+		return *i.Keys.Stop
+	}
+	return global
+}
+
+// ResolveInstanceRetention returns the retention policy for an instance on the given host.
+// The user.iab.retention.<role> key of the instance takes precedence over ResolveRetention.
+func (c Config) ResolveInstanceRetention(host Host, project string, inst Instance) string {
+	if pol, ok := inst.Keys.Retention[host.Role]; ok {
+		return pol
+	}
+	return c.ResolveRetention(host, project, RetentionInstances, inst.Name)
+}
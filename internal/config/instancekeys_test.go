@@ -0,0 +1,69 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseInstanceKeys(t *testing.T) {
+	keys, err := ParseInstanceKeys(map[string]string{
+		KeyRetentionSource: "6,1d2w",
+		KeyTargetPool:      "fast",
+		KeyExcludeDevices:  "eth1, gpu0,",
+		KeyStop:            "true",
+		"user.other":       "ignored",
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if keys.Retention["source"] != "6,1d2w" || len(keys.Retention) != 1 {
+		t.Fatalf("retention=%v", keys.Retention)
+	}
+	if keys.TargetPool != "fast" || !slices.Equal(keys.ExcludeDevices, []string{"eth1", "gpu0"}) || keys.Stop == nil || !*keys.Stop {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	for key, value := range map[string]string{
+		KeyRetentionTarget: "every day",
+		KeyStop:            "sometimes",
+	} {
+		_, err := ParseInstanceKeys(map[string]string{key: value})
+		if err == nil {
+			t.Errorf("%s=%q: expected an error", key, value)
+		}
+	}
+}
+
+func TestInstanceKeysPrecedence(t *testing.T) {
+	cfg := Config{Retention: RetentionConfig{Hosts: map[string]HostRetention{
+		"source": {Default: "3", Projects: map[string]ProjectRetention{
+			"default": {Instances: RetentionGroup{ByName: map[string]string{"c1": "5"}}},
+		}},
+		"target": {Default: "10"},
+	}}}
+	source := Host{Name: "prod", Role: "source"}
+	target := Host{Name: "nas", Role: "target"}
+
+	inst := Instance{Name: "c1", Storage: "local", ExcludeDevices: []string{"eth1"}}
+	inst.Keys, _ = ParseInstanceKeys(map[string]string{
+		KeyRetentionSource: "7",
+		KeyTargetPool:      "fast",
+		KeyExcludeDevices:  "eth1,gpu0",
+		KeyStop:            "false",
+	})
+
+	if pol := cfg.ResolveInstanceRetention(source, "default", inst); pol != "7" {
+		t.Errorf("source policy=%q, the key should win over byName", pol)
+	}
+	if pol := cfg.ResolveInstanceRetention(target, "default", inst); pol != "10" {
+		t.Errorf("target policy=%q, without key the config applies", pol)
+	}
+	if inst.TargetPool() != "fast" || !slices.Equal(inst.DevicesToExclude(), []string{"eth1", "gpu0"}) || inst.StopInstance(true) {
+		t.Errorf("keys not applied: pool=%q devices=%v stop=%v", inst.TargetPool(), inst.DevicesToExclude(), inst.StopInstance(true))
+	}
+
+	plain := Instance{Name: "c2", Storage: "local"}
+	if plain.TargetPool() != "local" || !plain.StopInstance(true) {
+		t.Errorf("config settings should apply without keys")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ProjectOn returns the name of the project on the host. Targets use targetProject.
+func (p Project) ProjectOn(h Host) string {
+	if h.Role == "target" && p.TargetProject != "" {
+		return p.TargetProject
+	}
+	return p.Name
+}
+
+// NameOn returns the name of an instance or volume of the project on the host.
+// Targets add targetPrefix and targetSuffix.
+func (p Project) NameOn(h Host, name string) string {
+	if h.Role == "target" {
+		return p.TargetPrefix + name + p.TargetSuffix
+	}
+	return name
+}
+
+// instance and volume names are used as host names, so affixes are limited to their characters
+var validAffix = regexp.MustCompile(`^[A-Za-z0-9-]*$`)
+
+func (c Config) validateNaming() []error {
+	var errs []error
+
+	seen := make(map[string]string) // host/project/resource on a target -> project entry
+	for _, p := range c.Projects {
+		if strings.ContainsAny(p.TargetProject, "/ \t") {
+			errs = append(errs, fmt.Errorf("projects.%s.targetProject: invalid project name %q", p.Name, p.TargetProject))
+		}
+		for field, v := range map[string]string{"targetPrefix": p.TargetPrefix, "targetSuffix": p.TargetSuffix} {
+			if !validAffix.MatchString(v) {
+				errs = append(errs, fmt.Errorf("projects.%s.%s: only letters, digits and dashes are allowed, got %q", p.Name, field, v))
+			}
+		}
+
+		// two projects landing on the same names of a shared target would overwrite each other
+		source, err := c.ProjectSource(p)
+		if err != nil {
+			continue // reported by validateTopology
+		}
+		for _, hop := range c.ReplicationHops(source) {
+			var resources []string
+			for _, inst := range p.Instances {
+				resources = append(resources, "instance "+p.NameOn(hop.To, inst.Name))
+			}
+			for _, vol := range p.Volumes {
+				resources = append(resources, "volume "+c.VolumePoolOn(hop.To, vol)+"/"+p.NameOn(hop.To, vol.Name))
+			}
+			for _, res := range resources {
+				key := hop.To.Name + "/" + p.ProjectOn(hop.To) + "/" + res
+				if other, ok := seen[key]; ok && other != p.Name+"@"+source.Name {
+					errs = append(errs, fmt.Errorf("projects.%s: %s in project %s on %s collides with project %s, set targetProject or targetPrefix",
+						p.Name, res, p.ProjectOn(hop.To), hop.To.Name, other))
+					continue
+				}
+				seen[key] = p.Name + "@" + source.Name
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProjectNaming(t *testing.T) {
+	p := Project{Name: "default", TargetProject: "site-a", TargetPrefix: "backup-"}
+	source := Host{Name: "prod", Role: "source"}
+	target := Host{Name: "nas", Role: "target"}
+
+	if got := p.ProjectOn(source); got != "default" {
+		t.Errorf("source project=%q", got)
+	}
+	if got := p.ProjectOn(target); got != "site-a" {
+		t.Errorf("target project=%q", got)
+	}
+	if got := p.NameOn(source, "c1"); got != "c1" {
+		t.Errorf("source name=%q", got)
+	}
+	if got := p.NameOn(target, "c1"); got != "backup-c1" {
+		t.Errorf("target name=%q", got)
+	}
+	if got := (Project{Name: "web"}).ProjectOn(target); got != "web" {
+		t.Errorf("unmapped target project=%q", got)
+	}
+}
+
+func TestValidateNaming(t *testing.T) {
+	hosts := []Host{
+		{Name: "a", Role: "source"},
+		{Name: "b", Role: "source"},
+		{Name: "nas", Role: "target"},
+	}
+
+	colliding := Config{Hosts: hosts, Projects: []Project{
+		{Name: "default", Source: "a", Instances: []Instance{{Name: "web"}}},
+		{Name: "default", Source: "b", Instances: []Instance{{Name: "web"}}},
+	}}
+	errs := colliding.validateNaming()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "instance web in project default on nas collides") {
+		t.Fatalf("expected a collision, got %v", errs)
+	}
+
+	mapped := Config{Hosts: hosts, Projects: []Project{
+		{Name: "default", Source: "a", TargetProject: "site-a", Instances: []Instance{{Name: "web"}}},
+		{Name: "default", Source: "b", TargetPrefix: "b-", Instances: []Instance{{Name: "web"}}},
+	}}
+	if errs := mapped.validateNaming(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	invalid := Config{Hosts: hosts, Projects: []Project{
+		{Name: "default", Source: "a", TargetProject: "a/b", TargetSuffix: "_bak"},
+	}}
+	if errs := invalid.validateNaming(); len(errs) != 2 {
+		t.Fatalf("errors=%d want 2: %v", len(errs), errs)
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// PoolOn returns the name of a source storage pool on the host. Targets use iab.poolMapping.
+func (c Config) PoolOn(h Host, pool string) string {
+	if h.Role == "target" && c.IAB.PoolMapping[pool] != "" {
+		return c.IAB.PoolMapping[pool]
+	}
+	return pool
+}
+
+// VolumePoolOn returns the pool of the volume on the host.
+// Targets use targetStorage, then iab.poolMapping, then storage.
+func (c Config) VolumePoolOn(h Host, vol Volume) string {
+	if h.Role == "target" && vol.TargetStorage != "" {
+		return vol.TargetStorage
+	}
+	return c.PoolOn(h, vol.Storage)
+}
+
+func (c Config) validatePools() []error {
+	var errs []error
+
+	for _, from := range slices.Sorted(maps.Keys(c.IAB.PoolMapping)) {
+		if from == "" || c.IAB.PoolMapping[from] == "" {
+			errs = append(errs, fmt.Errorf("iab.poolMapping: source and target pool are required, got %q -> %q", from, c.IAB.PoolMapping[from]))
+		}
+	}
+	for _, p := range c.Projects {
+		for _, vol := range p.Volumes {
+			if vol.TargetStorage != "" && vol.Storage == "" {
+				errs = append(errs, fmt.Errorf("projects.%s.volumes.%s: targetStorage requires storage", p.Name, vol.Name))
+			}
+		}
+	}
+
+	return errs
+}
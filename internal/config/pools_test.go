@@ -0,0 +1,42 @@
+package config
+
+import "testing"
+
+func TestVolumePoolOn(t *testing.T) {
+	cfg := Config{IAB: IAB{PoolMapping: map[string]string{"fast": "bulk"}}}
+	source := Host{Name: "prod", Role: "source"}
+	target := Host{Name: "nas", Role: "target"}
+
+	tests := []struct {
+		name string
+		host Host
+		vol  Volume
+		want string
+	}{
+		{"source keeps storage", source, Volume{Storage: "fast", TargetStorage: "archive"}, "fast"},
+		{"targetStorage wins", target, Volume{Storage: "fast", TargetStorage: "archive"}, "archive"},
+		{"pool mapping", target, Volume{Storage: "fast"}, "bulk"},
+		{"unmapped pool", target, Volume{Storage: "default"}, "default"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cfg.VolumePoolOn(tc.host, tc.vol); got != tc.want {
+				t.Fatalf("VolumePoolOn=%q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidatePools(t *testing.T) {
+	cfg := Config{
+		IAB: IAB{PoolMapping: map[string]string{"fast": "", "default": "bulk"}},
+		Projects: []Project{{
+			Name:    "web",
+			Volumes: []Volume{{Name: "data", TargetStorage: "bulk"}, {Name: "logs", Storage: "fast", TargetStorage: "bulk"}},
+		}},
+	}
+
+	if errs := cfg.validatePools(); len(errs) != 2 {
+		t.Fatalf("errors=%d want 2: %v", len(errs), errs)
+	}
+}
@@ -7,10 +7,15 @@ const (
 	RetentionVolumes   RetentionKind = "volumes"
 )
 
-func (c Config) ResolveRetention(role, project string, kind RetentionKind, name string) string {
+// ResolveRetention returns the retention policy for a resource on the given host.
+// A retention entry keyed by the host name takes precedence over the entry keyed by its role.
+func (c Config) ResolveRetention(host Host, project string, kind RetentionKind, name string) string {
 	var retentionPolicy string
 
-	hr, ok := c.Retention.Hosts[role]
+	hr, ok := c.Retention.Hosts[host.Name]
+	if !ok {
+		hr, ok = c.Retention.Hosts[host.Role]
+	}
 	if ok && hr.Default != "" {
 		retentionPolicy = hr.Default
 	}
@@ -3,11 +3,9 @@ package config
 func NewPostOnboardConfig(iabCredDir, sourceURL, targetURL, uuid string) Config {
 	return Config{
 		IAB: IAB{
-			IABCredDir:      iabCredDir,
-			UUID:            uuid,
-			StopInstance:    false,
-			HealthchecksURL: "",
-			GotifyURL:       "",
+			IABCredDir:   iabCredDir,
+			UUID:         uuid,
+			StopInstance: false,
 		},
 		Hosts: []Host{
 			{Role: "source", URL: sourceURL, Name: ""},
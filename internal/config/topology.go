@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Hop is a single replication step: instances and volumes are copied from From to To.
+type Hop struct {
+	From  Host
+	To    Host
+	Depth int
+}
+
+// HostByName returns the configured host with the given name.
+func (c Config) HostByName(name string) (Host, bool) {
+	for _, h := range c.Hosts {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return Host{}, false
+}
+
+// replicatesFrom reports whether to is refreshed from from.
+// Targets without "from" are refreshed from every source host.
+func replicatesFrom(to, from Host) bool {
+	if to.From != "" {
+		return to.From == from.Name
+	}
+	return from.Role == "source"
+}
+
+// ProjectSource returns the source host a project is backed up from.
+// Projects without "source" use the only configured source host.
+func (c Config) ProjectSource(p Project) (Host, error) {
+	if p.Source != "" {
+		h, ok := c.HostByName(p.Source)
+		if !ok || h.Role != "source" {
+			return Host{}, fmt.Errorf("projects.%s: %q is not a host with role 'source'", p.Name, p.Source)
+		}
+		return h, nil
+	}
+
+	sources := c.HostsByRole("source")
+	if len(sources) != 1 {
+		return Host{}, fmt.Errorf("projects.%s: 'source' is required when %d source hosts are configured", p.Name, len(sources))
+	}
+	return sources[0], nil
+}
+
+// ReplicationHops returns all hops reachable from source, ordered by depth.
+// A host is always refreshed before it is used as the origin of the next hop.
+func (c Config) ReplicationHops(source Host) []Hop {
+	var hops []Hop
+
+	frontier := []Host{source}
+	for depth := 1; len(frontier) > 0; depth++ {
+		var next []Host
+		for _, from := range frontier {
+			for _, to := range c.HostsByRole("target") {
+				if !replicatesFrom(to, from) {
+					continue
+				}
+				hops = append(hops, Hop{From: from, To: to, Depth: depth})
+				next = append(next, to)
+			}
+		}
+		frontier = next
+	}
+	return hops
+}
+
+func (c Config) validateTopology() []error {
+	var errs []error
+
+	for _, h := range c.Hosts {
+		if h.From == "" {
+			continue
+		}
+		if h.Role == "source" {
+			errs = append(errs, fmt.Errorf("hosts.%s: 'from' is only allowed on target hosts", h.Name))
+			continue
+		}
+		if h.From == h.Name {
+			errs = append(errs, fmt.Errorf("hosts.%s: host cannot replicate from itself", h.Name))
+			continue
+		}
+		if _, ok := c.HostByName(h.From); !ok {
+			errs = append(errs, fmt.Errorf("hosts.%s: unknown upstream host %q", h.Name, h.From))
+			continue
+		}
+
+		// follow the chain upwards, it has to end at a source
+		seen := map[string]struct{}{h.Name: {}}
+		cur := h
+		for cur.From != "" {
+			up, ok := c.HostByName(cur.From)
+			if !ok {
+				break
+			}
+			if _, loop := seen[up.Name]; loop {
+				errs = append(errs, fmt.Errorf("hosts.%s: replication chain contains a cycle", h.Name))
+				break
+			}
+			seen[up.Name] = struct{}{}
+			cur = up
+		}
+	}
+
+	for _, p := range c.Projects {
+		if _, err := c.ProjectSource(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
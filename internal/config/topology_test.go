@@ -0,0 +1,92 @@
+package config
+
+import "testing"
+
+func TestReplicationHops_Chain(t *testing.T) {
+	cfg := Config{Hosts: []Host{
+		{Name: "offsite", Role: "target", From: "backup"},
+		{Name: "prod", Role: "source"},
+		{Name: "backup", Role: "target"},
+		{Name: "nas", Role: "target"},
+	}}
+
+	hops := cfg.ReplicationHops(Host{Name: "prod", Role: "source"})
+	if len(hops) != 3 {
+		t.Fatalf("hops=%d want 3", len(hops))
+	}
+
+	want := []struct {
+		from, to string
+		depth    int
+	}{
+		{"prod", "backup", 1},
+		{"prod", "nas", 1},
+		{"backup", "offsite", 2},
+	}
+	for i, w := range want {
+		if hops[i].From.Name != w.from || hops[i].To.Name != w.to || hops[i].Depth != w.depth {
+			t.Fatalf("hop %d = %s->%s (%d), want %s->%s (%d)", i, hops[i].From.Name, hops[i].To.Name, hops[i].Depth, w.from, w.to, w.depth)
+		}
+	}
+}
+
+func TestValidateTopology_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []Host
+	}{
+		{name: "cycle", hosts: []Host{
+			{Name: "prod", Role: "source"},
+			{Name: "a", Role: "target", From: "b"},
+			{Name: "b", Role: "target", From: "a"},
+		}},
+		{name: "self", hosts: []Host{
+			{Name: "prod", Role: "source"},
+			{Name: "a", Role: "target", From: "a"},
+		}},
+		{name: "unknown upstream", hosts: []Host{
+			{Name: "prod", Role: "source"},
+			{Name: "a", Role: "target", From: "nope"},
+		}},
+		{name: "from on source", hosts: []Host{
+			{Name: "prod", Role: "source", From: "a"},
+			{Name: "a", Role: "target"},
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Hosts: tc.hosts}
+			if errs := cfg.validateTopology(); len(errs) == 0 {
+				t.Fatalf("expected topology error")
+			}
+		})
+	}
+}
+
+func TestProjectSource_MultipleSources(t *testing.T) {
+	cfg := Config{
+		Hosts: []Host{
+			{Name: "srv1", Role: "source"},
+			{Name: "srv2", Role: "source"},
+			{Name: "central", Role: "target"},
+		},
+	}
+
+	if _, err := cfg.ProjectSource(Project{Name: "default"}); err == nil {
+		t.Fatalf("expected error for project without source")
+	}
+	if _, err := cfg.ProjectSource(Project{Name: "default", Source: "central"}); err == nil {
+		t.Fatalf("expected error for project referencing a target")
+	}
+
+	src, err := cfg.ProjectSource(Project{Name: "default", Source: "srv2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hops := cfg.ReplicationHops(src)
+	if len(hops) != 1 || hops[0].From.Name != "srv2" || hops[0].To.Name != "central" {
+		t.Fatalf("unexpected hops: %+v", hops)
+	}
+}
@@ -0,0 +1,195 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/retention"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Run is one line of the history file.
+type Run struct {
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Status    string     `json:"status"`
+	Resources []Resource `json:"resources"`
+	Error     string     `json:"error,omitempty"` // the run failed before any task was started
+}
+
+// Resource is the outcome of all tasks of one instance or volume on one host in a run.
+// Copies count for their target host.
+type Resource struct {
+	Project  string   `json:"project"`
+	Resource string   `json:"resource"`
+	Host     string   `json:"host,omitempty"`
+	Status   string   `json:"status"`
+	Snapshot string   `json:"snapshot,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// RunFromReport condenses a run report to one outcome per resource.
+// A resource failed if any of its tasks failed and is skipped if any task was not started.
+func RunFromReport(r *runner.Report) Run {
+	run := Run{Start: r.Start, End: r.End, Status: r.Status}
+
+	index := make(map[string]int)
+	for _, t := range r.Tasks {
+		key := t.Project + "/" + t.Resource + "@" + t.Host
+		i, ok := index[key]
+		if !ok {
+			i = len(run.Resources)
+			index[key] = i
+			run.Resources = append(run.Resources, Resource{Project: t.Project, Resource: t.Resource, Host: t.Host, Status: StatusSuccess})
+		}
+		res := &run.Resources[i]
+
+		switch t.Status {
+		case runner.TaskFailed:
+			res.Status = StatusFailed
+			res.Errors = append(res.Errors, t.Error)
+		case runner.TaskSkipped:
+			if res.Status != StatusFailed {
+				res.Status = StatusSkipped
+			}
+		}
+		if len(t.SnapshotsCreated) > 0 {
+			res.Snapshot = t.SnapshotsCreated[len(t.SnapshotsCreated)-1]
+		}
+	}
+	return run
+}
+
+// FailedRun is the history entry of a run which failed before its tasks were executed,
+// e.g. because a host could not be connected or the lock was held.
+func FailedRun(start, end time.Time, err error) Run {
+	status := StatusFailed
+	if errors.Is(err, context.Canceled) {
+		status = "aborted"
+	}
+	return Run{Start: start, End: end, Status: status, Error: err.Error()}
+}
+
+// Append adds run to the history file, the file is created if needed.
+func Append(path string, run Run) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open history %s: %w", path, err)
+	}
+	_, err = f.Write(append(b, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// Load reads all runs in file order. Lines which cannot be parsed (e.g. a write
+// interrupted by a crash) are skipped and counted.
+func Load(path string) (runs []Run, invalid int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open history %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var run Run
+		if json.Unmarshal([]byte(line), &run) != nil {
+			invalid++
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, invalid, sc.Err()
+}
+
+// ResourceStatus is the backup state of one instance or volume over all recorded runs.
+type ResourceStatus struct {
+	Project             string    `json:"project"`
+	Resource            string    `json:"resource"`
+	Host                string    `json:"host,omitempty"`
+	LastSuccess         time.Time `json:"lastSuccess,omitzero"`
+	LastFailure         time.Time `json:"lastFailure,omitzero"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastSnapshot        string    `json:"lastSnapshot,omitempty"`
+	LastSnapshotTime    time.Time `json:"lastSnapshotTime,omitzero"`
+}
+
+// Summarize computes the state of every resource on every host seen in runs, which must be in chronological order.
+// Skipped outcomes neither count as success nor as failure. A run which failed before any task counts as a
+// failure of every resource seen so far, aborted runs do not count.
+func Summarize(runs []Run) []ResourceStatus {
+	var out []ResourceStatus
+	index := make(map[string]int)
+
+	for _, run := range runs {
+		if run.Error != "" && run.Status == StatusFailed && len(run.Resources) == 0 {
+			for i := range out {
+				out[i].LastFailure = run.End
+				out[i].LastError = run.Error
+				out[i].ConsecutiveFailures++
+			}
+			continue
+		}
+		for _, res := range run.Resources {
+			key := res.Project + "/" + res.Resource + "@" + res.Host
+			i, ok := index[key]
+			if !ok {
+				i = len(out)
+				index[key] = i
+				out = append(out, ResourceStatus{Project: res.Project, Resource: res.Resource, Host: res.Host})
+			}
+			st := &out[i]
+
+			switch res.Status {
+			case StatusSuccess:
+				st.LastSuccess = run.End
+				st.ConsecutiveFailures = 0
+			case StatusFailed:
+				st.LastFailure = run.End
+				st.ConsecutiveFailures++
+				if len(res.Errors) > 0 {
+					st.LastError = res.Errors[len(res.Errors)-1]
+				}
+			}
+
+			if res.Snapshot != "" {
+				st.LastSnapshot = res.Snapshot
+				st.LastSnapshotTime, _ = retention.ParseIABSnapshotTime(res.Snapshot)
+			}
+		}
+	}
+
+	slices.SortFunc(out, func(a, b ResourceStatus) int {
+		if c := strings.Compare(a.Project, b.Project); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Resource, b.Resource); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Host, b.Host)
+	})
+	return out
+}
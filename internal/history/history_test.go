@@ -0,0 +1,132 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+func TestRunFromReport(t *testing.T) {
+	report := &runner.Report{
+		Status: "failed",
+		Tasks: []*runner.TaskReport{
+			{Kind: runner.KindSnapshot, TaskInfo: runner.TaskInfo{Project: "default", Resource: "instance/c1"}, Status: runner.TaskSuccess, SnapshotsCreated: []string{"IAB_20260301-030000"}},
+			{Kind: runner.KindCopy, TaskInfo: runner.TaskInfo{Project: "default", Resource: "instance/c1"}, Status: runner.TaskFailed, Error: "connection reset"},
+			{Kind: runner.KindSnapshot, TaskInfo: runner.TaskInfo{Project: "default", Resource: "volume/local/v1"}, Status: runner.TaskSuccess},
+		},
+	}
+
+	run := RunFromReport(report)
+	if len(run.Resources) != 2 {
+		t.Fatalf("resources=%d want 2", len(run.Resources))
+	}
+	c1 := run.Resources[0]
+	if c1.Status != StatusFailed || c1.Snapshot != "IAB_20260301-030000" || len(c1.Errors) != 1 {
+		t.Fatalf("unexpected c1 outcome: %+v", c1)
+	}
+	if run.Resources[1].Status != StatusSuccess {
+		t.Fatalf("v1 status=%s want success", run.Resources[1].Status)
+	}
+}
+
+func TestAppendLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 3, 0, 0, 0, time.UTC) }
+	res := func(status string) []Resource {
+		return []Resource{{Project: "default", Resource: "instance/c1", Status: status}}
+	}
+
+	for i, status := range []string{StatusSuccess, StatusFailed, StatusSkipped, StatusFailed} {
+		err := Append(path, Run{Start: day(i + 1), End: day(i + 1), Resources: res(status)})
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	// a run interrupted while writing leaves a partial line behind
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = f.WriteString(`{"start":"2026-03-05`)
+	_ = f.Close()
+
+	runs, invalid, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(runs) != 4 || invalid != 1 {
+		t.Fatalf("runs=%d invalid=%d want 4 and 1", len(runs), invalid)
+	}
+
+	st := Summarize(runs)
+	if len(st) != 1 {
+		t.Fatalf("statuses=%d want 1", len(st))
+	}
+	if !st[0].LastSuccess.Equal(day(1)) || !st[0].LastFailure.Equal(day(4)) || st[0].ConsecutiveFailures != 2 {
+		t.Fatalf("unexpected status: %+v", st[0])
+	}
+}
+
+func TestRunFromReport_PerHost(t *testing.T) {
+	on := func(host string) runner.TaskInfo {
+		return runner.TaskInfo{Project: "default", Resource: "instance/c1", Host: host}
+	}
+	report := &runner.Report{
+		End: time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC),
+		Tasks: []*runner.TaskReport{
+			{Kind: runner.KindCopy, TaskInfo: on("nas"), Status: runner.TaskSuccess},
+			{Kind: runner.KindCopy, TaskInfo: on("offsite"), Status: runner.TaskFailed, Error: "unreachable"},
+		},
+	}
+
+	st := Summarize([]Run{RunFromReport(report)})
+	if len(st) != 2 {
+		t.Fatalf("statuses=%d want 2: %+v", len(st), st)
+	}
+	if st[0].Host != "nas" || st[0].LastSuccess.IsZero() || st[0].ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected nas status: %+v", st[0])
+	}
+	if st[1].Host != "offsite" || !st[1].LastSuccess.IsZero() || st[1].LastError != "unreachable" {
+		t.Fatalf("unexpected offsite status: %+v", st[1])
+	}
+}
+
+func TestFailedRun(t *testing.T) {
+	run := FailedRun(time.Now(), time.Now(), errors.New("lock held"))
+	if run.Status != StatusFailed || run.Error != "lock held" || len(run.Resources) != 0 {
+		t.Fatalf("unexpected run: %+v", run)
+	}
+	if run := FailedRun(time.Now(), time.Now(), context.Canceled); run.Status != "aborted" {
+		t.Fatalf("status=%s want aborted", run.Status)
+	}
+}
+
+func TestSummarize_FailedBeforeTasks(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 3, 0, 0, 0, time.UTC) }
+	ok := Run{End: day(1), Status: StatusSuccess, Resources: []Resource{
+		{Project: "default", Resource: "instance/c1", Host: "src", Status: StatusSuccess},
+		{Project: "default", Resource: "instance/c1", Host: "nas", Status: StatusSuccess},
+	}}
+	runs := []Run{
+		ok,
+		FailedRun(day(2), day(2), errors.New("connect nas: no route to host")),
+		FailedRun(day(3), day(3), context.Canceled),
+		FailedRun(day(4), day(4), errors.New("lock held")),
+	}
+
+	for _, st := range Summarize(runs) {
+		if st.ConsecutiveFailures != 2 || !st.LastFailure.Equal(day(4)) || st.LastError != "lock held" || !st.LastSuccess.Equal(day(1)) {
+			t.Fatalf("unexpected status: %+v", st)
+		}
+	}
+
+	ok.End = day(5)
+	for _, st := range Summarize(append(runs, ok)) {
+		if st.ConsecutiveFailures != 0 {
+			t.Fatalf("failures=%d after a successful run", st.ConsecutiveFailures)
+		}
+	}
+}
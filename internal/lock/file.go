@@ -0,0 +1,61 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrLocked is returned when another run holds the lock.
+var ErrLocked = errors.New("another IAB run holds the lock")
+
+// File is an exclusive lock on a local file. The kernel drops it when the process exits,
+// so a crashed run never leaves a stale lock behind.
+type File struct {
+	f *os.File
+}
+
+// AcquireFile takes the lock on path without blocking. The file is created if needed
+// and contains the PID of the holder.
+func AcquireFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open lockfile %s: %w", path, err)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		b, _ := os.ReadFile(path)
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: lockfile %s held by pid %s", ErrLocked, path, strings.TrimSpace(string(b)))
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write lockfile %s: %w", path, err)
+	}
+
+	return &File{f: f}, nil
+}
+
+// Release unlocks the file. The file itself is kept, removing it would race with the next run.
+func (l *File) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = l.f.Truncate(0)
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	closeErr := l.f.Close()
+	l.f = nil
+	return errors.Join(err, closeErr)
+}
@@ -0,0 +1,145 @@
+package lock
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+func TestAcquireFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iab.lock")
+
+	l, err := AcquireFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = AcquireFile(path)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second acquire err=%v want ErrLocked", err)
+	}
+
+	err = l.Release()
+	if err != nil {
+		t.Fatalf("release: %v", err)
+	}
+
+	l, err = AcquireFile(path)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	_ = l.Release()
+}
+
+// fakeServer keeps the server config in memory and honours ETags like Incus.
+type fakeServer struct {
+	incus.InstanceServer
+	config map[string]string
+	etag   string
+}
+
+func (s *fakeServer) GetServer() (*api.Server, string, error) {
+	cfg := make(map[string]string, len(s.config))
+	for k, v := range s.config {
+		cfg[k] = v
+	}
+	srv := &api.Server{}
+	srv.Config = cfg
+	return srv, s.etag, nil
+}
+
+func (s *fakeServer) RawQuery(method, path string, data any, etag string) (*api.Response, string, error) {
+	if etag != s.etag {
+		return nil, "", api.StatusErrorf(http.StatusPreconditionFailed, "ETag doesn't match")
+	}
+	for k, v := range data.(api.ServerPut).Config {
+		if v == "" {
+			delete(s.config, k)
+			continue
+		}
+		s.config[k] = v
+	}
+	s.etag += "x"
+	return &api.Response{}, s.etag, nil
+}
+
+func TestAcquireServer(t *testing.T) {
+	srv := &fakeServer{config: map[string]string{"core.https_address": ":8443"}, etag: "1"}
+	now := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+
+	l, err := AcquireServer(srv, "prod", "uuid-a", time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if srv.config["core.https_address"] != ":8443" {
+		t.Fatalf("unrelated server config was modified: %v", srv.config)
+	}
+
+	_, err = AcquireServer(srv, "prod", "uuid-b", time.Hour, now.Add(30*time.Minute))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("err=%v want ErrLocked", err)
+	}
+
+	// expired locks are taken over
+	stale, err := AcquireServer(srv, "prod", "uuid-b", time.Hour, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("takeover of expired lock: %v", err)
+	}
+
+	err = l.Release()
+	if err == nil {
+		t.Fatalf("release of a lock taken over by another runner should fail")
+	}
+
+	// a runner with a copied UUID is another process
+	_, err = acquireServer(srv, "prod", "uuid-b", "other-process", time.Hour, now.Add(2*time.Hour))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("err=%v want ErrLocked for the same owner in another process", err)
+	}
+	_, err = AcquireServer(srv, "prod", "uuid-b", time.Hour, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("renew by the holding process: %v", err)
+	}
+	err = stale.Release()
+	if err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, ok := srv.config[ServerKey]; ok {
+		t.Fatalf("%s still set after release", ServerKey)
+	}
+}
+
+func TestRenewServer(t *testing.T) {
+	srv := &fakeServer{config: map[string]string{}, etag: "1"}
+	now := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+
+	l, err := AcquireServer(srv, "prod", "uuid-a", time.Hour, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a run longer than the TTL keeps its lock
+	err = l.Renew(time.Hour, now.Add(50*time.Minute))
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	_, err = AcquireServer(srv, "prod", "uuid-b", time.Hour, now.Add(90*time.Minute))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("err=%v want ErrLocked after renew", err)
+	}
+
+	// an expired lock taken over by another runner is not renewed
+	_, err = AcquireServer(srv, "prod", "uuid-b", time.Hour, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("takeover of expired lock: %v", err)
+	}
+	err = l.Renew(time.Hour, now.Add(3*time.Hour))
+	if err == nil {
+		t.Fatalf("renew of a lock taken over by another runner should fail")
+	}
+}
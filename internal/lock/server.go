@@ -0,0 +1,139 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// ServerKey is the Incus server config key holding the distributed lock.
+const ServerKey = "user.iab.lock"
+
+// serverLockValue is stored as JSON in ServerKey.
+type serverLockValue struct {
+	Owner   string    `json:"owner"`
+	Token   string    `json:"token,omitempty"` // identifies the process, runners may share a copied UUID
+	Runner  string    `json:"runner,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// processToken tells the locks of this process from those of other processes with the same owner.
+var processToken = rand.Text()
+
+// Server is a lock stored in the config of an Incus server, shared by all runners talking to it.
+// Locks past their expiry are considered stale and are taken over.
+type Server struct {
+	client incus.InstanceServer
+	host   string
+	owner  string
+	token  string
+}
+
+// AcquireServer takes the lock on the server for owner (the IAB UUID) until now+ttl.
+// A lock already held by this process is renewed, a lock of another process is not, even with the same owner.
+// Concurrent attempts are serialized via the server ETag.
+func AcquireServer(client incus.InstanceServer, hostName, owner string, ttl time.Duration, now time.Time) (*Server, error) {
+	return acquireServer(client, hostName, owner, processToken, ttl, now)
+}
+
+func acquireServer(client incus.InstanceServer, hostName, owner, token string, ttl time.Duration, now time.Time) (*Server, error) {
+	srv, etag, err := client.GetServer()
+	if err != nil {
+		return nil, fmt.Errorf("lock %s: get server config: %w", hostName, err)
+	}
+
+	raw := srv.Config[ServerKey]
+	if raw != "" {
+		var cur serverLockValue
+		err := json.Unmarshal([]byte(raw), &cur)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: invalid %s value %q, remove it manually: %w", hostName, ServerKey, raw, err)
+		}
+		if (cur.Owner != owner || cur.Token != token) && now.Before(cur.Expires) {
+			return nil, fmt.Errorf("%w: %s on %s held by %s (%s) until %s", ErrLocked, ServerKey, hostName, cur.Owner, cur.Runner, cur.Expires.Format(time.RFC3339))
+		}
+	}
+
+	runner, _ := os.Hostname()
+	val, err := json.Marshal(serverLockValue{Owner: owner, Token: token, Runner: runner, Expires: now.Add(ttl).UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	err = patchServerConfig(client, string(val), etag)
+	if err != nil {
+		if _, ok := api.StatusErrorMatch(err, http.StatusPreconditionFailed); ok {
+			return nil, fmt.Errorf("%w: %s on %s was changed concurrently", ErrLocked, ServerKey, hostName)
+		}
+		return nil, fmt.Errorf("lock %s: set %s: %w", hostName, ServerKey, err)
+	}
+
+	return &Server{client: client, host: hostName, owner: owner, token: token}, nil
+}
+
+// Renew moves the expiry of the lock to now+ttl, so runs taking longer than the TTL keep their lock.
+// It fails if the lock is no longer held by the owner, e.g. after it expired and was taken over.
+func (l *Server) Renew(ttl time.Duration, now time.Time) error {
+	cur, etag, err := l.current("renew")
+	if err != nil {
+		return err
+	}
+
+	cur.Expires = now.Add(ttl).UTC()
+	val, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	err = patchServerConfig(l.client, string(val), etag)
+	if err != nil {
+		return fmt.Errorf("renew lock %s: %w", l.host, err)
+	}
+	return nil
+}
+
+// Release removes the lock if it is still held by the owner.
+func (l *Server) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	_, etag, err := l.current("unlock")
+	if err != nil {
+		return err
+	}
+
+	err = patchServerConfig(l.client, "", etag)
+	if err != nil {
+		return fmt.Errorf("unlock %s: %w", l.host, err)
+	}
+	return nil
+}
+
+// current returns the lock value and the ETag of the server config, if the lock is still held by the owner.
+func (l *Server) current(op string) (serverLockValue, string, error) {
+	srv, etag, err := l.client.GetServer()
+	if err != nil {
+		return serverLockValue{}, "", fmt.Errorf("%s %s: get server config: %w", op, l.host, err)
+	}
+
+	var cur serverLockValue
+	if json.Unmarshal([]byte(srv.Config[ServerKey]), &cur) != nil || cur.Owner != l.owner || cur.Token != l.token {
+		return serverLockValue{}, "", fmt.Errorf("%s %s: %s is no longer held by this run of %s", op, l.host, ServerKey, l.owner)
+	}
+	return cur, etag, nil
+}
+
+// patchServerConfig sets ServerKey only, an empty value removes it.
+// UpdateServer would replace the whole server config, so PATCH is used instead.
+func patchServerConfig(client incus.InstanceServer, value, etag string) error {
+	put := api.ServerPut{Config: map[string]string{ServerKey: value}}
+	_, _, err := client.RawQuery(http.MethodPatch, "/1.0", put, etag)
+	return err
+}
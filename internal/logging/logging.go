@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Options configure the logger of a run.
+type Options struct {
+	Format     string // text (default) or json
+	Level      slog.Level
+	AddSource  bool
+	File       string // empty: log to the default writer
+	MaxSize    int64  // rotate the file beyond this many bytes, 0 disables rotation
+	MaxBackups int
+}
+
+// New creates the logger described by opts. Without a file the logger writes to w.
+// The returned closer releases the log file and must be called before exiting.
+func New(opts Options, w io.Writer) (*slog.Logger, io.Closer, error) {
+	var closer io.Closer = io.NopCloser(nil)
+	if opts.File != "" {
+		f, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level, AddSource: opts.AddSource}
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), closer, nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), closer, nil
+	default:
+		_ = closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q, expected text or json", opts.Format)
+	}
+}
+
+// ParseLevel converts debug, info, warn or error to a level. Unknown values yield info.
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "info", "":
+		return slog.LevelInfo
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
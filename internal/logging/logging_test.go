@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, closer, err := New(Options{Format: "json", Level: slog.LevelInfo}, &buf)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer func() { _ = closer.Close() }()
+
+	logger.Debug("hidden")
+	logger.Info("task failed", "task", "copy c1")
+
+	var rec map[string]any
+	err = json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "task failed" || rec["task"] != "copy c1" {
+		t.Fatalf("unexpected record: %v", rec)
+	}
+	if _, ok := rec["source"]; ok {
+		t.Fatal("source added without AddSource")
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	_, _, err := New(Options{Format: "xml"}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iab.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		_, err := f.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// every line exceeds the limit together with the previous one, the oldest is dropped
+	for name, want := range map[string]string{
+		path:        "dddddd\n",
+		path + ".1": "cccccc\n",
+		path + ".2": "bbbbbb\n",
+	} {
+		b, err := os.ReadFile(name)
+		if err != nil || string(b) != want {
+			t.Fatalf("%s: got %q (%v) want %q", filepath.Base(name), b, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected no third backup, got %v", err)
+	}
+}
+
+func TestRotatingFileKeepsWritingAfterFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iab.log")
+	// a non-empty directory in place of the first backup cannot be replaced by the log file
+	_ = os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755)
+
+	f, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	_, err = f.Write([]byte("aaaaaa\n"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	n, err := f.Write([]byte("bbbbbb\n"))
+	if err == nil || n != 7 {
+		t.Fatalf("expected the rotation error after writing, got n=%d err=%v", n, err)
+	}
+	_, _ = f.Write([]byte("cccccc\n"))
+
+	b, _ := os.ReadFile(path)
+	if string(b) != "aaaaaa\nbbbbbb\ncccccc\n" {
+		t.Fatalf("unexpected log file: %q", b)
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iab.log")
+	_ = os.WriteFile(path, []byte("old\n"), 0o644)
+
+	logger, closer, err := New(Options{File: path, MaxSize: 1 << 20}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	logger.Info("new")
+	_ = closer.Close()
+
+	b, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(b), "old\n") || !strings.Contains(string(b), "msg=new") {
+		t.Fatalf("unexpected log file: %q", b)
+	}
+}
@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/history"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// LastSuccessMetric keeps its value across runs, see ReadLastSuccess.
+const LastSuccessMetric = "iab_last_success_timestamp_seconds"
+
+type sample struct {
+	labels string // rendered label set incl. braces, "" for none
+	value  float64
+}
+
+type family struct {
+	name, help, typ string
+	samples         []sample
+}
+
+// Set is a collection of gauges in the Prometheus text exposition format.
+type Set struct {
+	families []*family
+	index    map[string]*family
+}
+
+func (s *Set) gauge(name, help string) *family {
+	if s.index == nil {
+		s.index = make(map[string]*family)
+	}
+	f, ok := s.index[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: "gauge"}
+		s.index[name] = f
+		s.families = append(s.families, f)
+	}
+	return f
+}
+
+func (f *family) set(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{labels: renderLabels(labels), value: value})
+}
+
+// renderLabels renders name/value pairs as {a="x",b="y"}.
+func renderLabels(kv []string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(kv[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteTo writes all metrics in the text exposition format.
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, f := range s.families {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, smp := range f.samples {
+			fmt.Fprintf(&buf, "%s%s %s\n", f.name, smp.labels, strconv.FormatFloat(smp.value, 'g', -1, 64))
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// FromRun builds the metrics of a run. report is nil if the run failed before any task started.
+// lastSuccess holds the iab_last_success_timestamp_seconds series of earlier runs keyed by label set,
+// resources which did not succeed in this run keep their previous value.
+func FromRun(report *runner.Report, success bool, now time.Time, lastSuccess map[string]float64) *Set {
+	s := &Set{}
+
+	s.gauge("iab_run_success", "Whether the last run finished without errors.").set(boolValue(success))
+	s.gauge("iab_run_timestamp_seconds", "Time the last run finished.").set(unix(now))
+
+	last := s.gauge(LastSuccessMetric, "Time of the last run in which all tasks of the resource succeeded.")
+	if report == nil {
+		for _, labels := range sortedKeys(lastSuccess) {
+			last.samples = append(last.samples, sample{labels: labels, value: lastSuccess[labels]})
+		}
+		return s
+	}
+
+	s.gauge("iab_run_duration_seconds", "Duration of the last run.").set(report.DurationSeconds)
+	tasks := s.gauge("iab_run_tasks", "Number of tasks of the last run by status.")
+	tasks.set(float64(report.Total-report.Failed-report.Skipped), "status", "success")
+	tasks.set(float64(report.Failed), "status", "failed")
+	tasks.set(float64(report.Skipped), "status", "skipped")
+	s.gauge("iab_run_retries", "Number of task retries in the last run.").set(float64(report.Retries))
+
+	// resources which succeeded now replace their previous value
+	current := make(map[string]float64, len(lastSuccess))
+	for k, v := range lastSuccess {
+		current[k] = v
+	}
+	// history entries are per host, a resource only succeeded if it succeeded on every host
+	succeeded := make(map[string]bool)
+	for _, res := range history.RunFromReport(report).Resources {
+		kind, name := splitResource(res.Resource)
+		labels := renderLabels([]string{"project", res.Project, "kind", kind, "name", name})
+		ok, seen := succeeded[labels]
+		succeeded[labels] = (ok || !seen) && res.Status == history.StatusSuccess
+	}
+	for labels, ok := range succeeded {
+		if ok {
+			current[labels] = unix(report.End)
+		}
+	}
+	for _, labels := range sortedKeys(current) {
+		last.samples = append(last.samples, sample{labels: labels, value: current[labels]})
+	}
+
+	duration := s.gauge("iab_task_duration_seconds", "Duration of the task in the last run.")
+	snapshots := s.gauge("iab_snapshots", "Number of IAB snapshots kept after pruning.")
+	unmanaged := s.gauge("iab_unmanaged_snapshots", "Number of snapshots not managed by IAB.")
+	prunedByRole := make(map[string]int)
+
+	for _, t := range report.Tasks {
+		if t.Status == runner.TaskSkipped {
+			continue
+		}
+		kind, name := splitResource(t.Resource)
+		duration.set(t.DurationSeconds, "task", string(t.Kind), "project", t.Project, "kind", kind, "name", name, "host", t.Host)
+
+		if t.Kind != runner.KindPrune || t.Status != runner.TaskSuccess {
+			continue
+		}
+		snapshots.set(float64(t.SnapshotsKept), "project", t.Project, "kind", kind, "name", name, "host", t.Host, "role", t.Role)
+		unmanaged.set(float64(t.SnapshotsUnmanaged), "project", t.Project, "kind", kind, "name", name, "host", t.Host)
+		prunedByRole[t.Role] += len(t.SnapshotsPruned)
+	}
+
+	pruned := s.gauge("iab_snapshots_pruned", "Number of IAB snapshots removed in the last run by host role.")
+	for _, role := range sortedKeys(prunedByRole) {
+		pruned.set(float64(prunedByRole[role]), "role", role)
+	}
+
+	return s
+}
+
+// ReadLastSuccess returns the iab_last_success_timestamp_seconds series of an existing textfile.
+// A missing file yields an empty map.
+func ReadLastSuccess(path string) (map[string]float64, error) {
+	out := make(map[string]float64)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, ok := strings.CutPrefix(sc.Text(), LastSuccessMetric+"{")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(line, "} ")
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(line[i+2:]), 64)
+		if err != nil {
+			continue
+		}
+		out["{"+line[:i+1]] = v
+	}
+	return out, sc.Err()
+}
+
+// WriteTextfile writes the metrics for the node_exporter textfile collector.
+// The file is replaced atomically so the collector never reads a partial file.
+func WriteTextfile(path string, s *Set) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = s.WriteTo(tmp)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Push replaces the metrics of the job on a Pushgateway.
+func Push(ctx context.Context, client *http.Client, gatewayURL, job string, s *Set) error {
+	var body bytes.Buffer
+	_, err := s.WriteTo(&body)
+	if err != nil {
+		return err
+	}
+
+	u := strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("pushgateway: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// splitResource splits "instance/c1" into kind and name, volumes keep their pool ("local/v1").
+func splitResource(resource string) (kind, name string) {
+	kind, name, _ = strings.Cut(resource, "/")
+	return kind, name
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unix(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
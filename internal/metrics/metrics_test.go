@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+func testReport(end time.Time) *runner.Report {
+	c1 := runner.TaskInfo{Project: "default", Resource: "instance/c1", Host: "src", Role: "source"}
+	v1 := runner.TaskInfo{Project: "default", Resource: "volume/local/v1", Host: "src", Role: "source"}
+	v1Copy := runner.TaskInfo{Project: "default", Resource: "volume/local/v1", Host: "tgt", Source: "src"}
+	return &runner.Report{
+		End:             end,
+		DurationSeconds: 42,
+		Status:          "failed",
+		Total:           4,
+		Failed:          1,
+		Retries:         2,
+		Tasks: []*runner.TaskReport{
+			{Kind: runner.KindSnapshot, TaskInfo: c1, Status: runner.TaskSuccess, DurationSeconds: 1.5},
+			{Kind: runner.KindPrune, TaskInfo: c1, Status: runner.TaskSuccess, SnapshotsPruned: []string{"IAB_a", "IAB_b"}, SnapshotsKept: 7, SnapshotsUnmanaged: 1},
+			{Kind: runner.KindSnapshot, TaskInfo: v1, Status: runner.TaskSuccess},
+			{Kind: runner.KindCopy, TaskInfo: v1Copy, Status: runner.TaskFailed}, // copies report the target host
+		},
+	}
+}
+
+func render(t *testing.T, s *Set) string {
+	t.Helper()
+	var b strings.Builder
+	_, err := s.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return b.String()
+}
+
+func TestFromRun(t *testing.T) {
+	end := time.Unix(1772334000, 0)
+	previous := map[string]float64{`{project="default",kind="volume",name="local/v1"}`: 1772247600}
+
+	out := render(t, FromRun(testReport(end), false, end, previous))
+
+	for _, want := range []string{
+		"# TYPE iab_run_success gauge\niab_run_success 0\n",
+		`iab_last_success_timestamp_seconds{project="default",kind="instance",name="c1"} 1.772334e+09`,
+		// the volume failed on tgt although its snapshot on src succeeded, it keeps its previous success
+		`iab_last_success_timestamp_seconds{project="default",kind="volume",name="local/v1"} 1.7722476e+09`,
+		`iab_task_duration_seconds{task="snapshot",project="default",kind="instance",name="c1",host="src"} 1.5`,
+		`iab_snapshots{project="default",kind="instance",name="c1",host="src",role="source"} 7`,
+		`iab_unmanaged_snapshots{project="default",kind="instance",name="c1",host="src"} 1`,
+		`iab_snapshots_pruned{role="source"} 2`,
+		`iab_run_tasks{status="success"} 3`,
+		`iab_run_retries 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestFromRunWithoutReport(t *testing.T) {
+	previous := map[string]float64{`{project="default",kind="instance",name="c1"}`: 1772247600}
+
+	out := render(t, FromRun(nil, false, time.Now(), previous))
+	if !strings.Contains(out, "iab_run_success 0") || !strings.Contains(out, `name="c1"} 1.7722476e+09`) {
+		t.Fatalf("unexpected metrics:\n%s", out)
+	}
+	if strings.Contains(out, "iab_run_tasks") {
+		t.Fatalf("task metrics without report:\n%s", out)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := renderLabels([]string{"name", "a\"b\\c\nd"})
+	if want := `{name="a\"b\\c\nd"}`; got != want {
+		t.Fatalf("got %s want %s", got, want)
+	}
+}
+
+func TestTextfileCarriesLastSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iab.prom")
+	first := time.Unix(1772247600, 0)
+
+	err := WriteTextfile(path, FromRun(testReport(first), false, first, nil))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	last, err := ReadLastSuccess(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(last) != 1 || last[`{project="default",kind="instance",name="c1"}`] != 1772247600 {
+		t.Fatalf("unexpected last success: %v", last)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o644 {
+		t.Fatalf("stat: %v mode=%v", err, info.Mode())
+	}
+
+	missing, err := ReadLastSuccess(filepath.Join(t.TempDir(), "none.prom"))
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("missing file: %v %v", missing, err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var method, path, contentType, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, contentType = r.Method, r.URL.Path, r.Header.Get("Content-Type")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	err := Push(context.Background(), srv.Client(), srv.URL+"/", "iab", FromRun(nil, true, time.Now(), nil))
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/iab" || !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("unexpected request %s %s (%s)", method, path, contentType)
+	}
+	if !strings.Contains(body, "iab_run_success 1") {
+		t.Fatalf("unexpected body:\n%s", body)
+	}
+}
+
+func TestPushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := Push(context.Background(), srv.Client(), srv.URL, "iab", &Set{})
+	if err == nil || !strings.Contains(err.Error(), "bad metrics") {
+		t.Fatalf("expected error with body, got %v", err)
+	}
+}
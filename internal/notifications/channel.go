@@ -0,0 +1,143 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// channelNotifier is a notifier of a named channel. It only sees the tasks of the resources routed
+// to the channel, the global notifiers keep receiving the overall result. The channel is started with
+// its first task, so a run without any of its resources does not leave e.g. a Healthchecks check started.
+type channelNotifier struct {
+	Notifier
+	channel string
+	routes  func(runner.TaskInfo) []string
+
+	mu      sync.Mutex
+	started bool
+}
+
+func (n *channelNotifier) Name() string { return "channel " + n.channel + ": " + n.Notifier.Name() }
+
+func (n *channelNotifier) routed(t runner.TaskInfo) bool {
+	return slices.Contains(n.routes(t), n.channel)
+}
+
+// Start is deferred to the first task routed to the channel.
+func (n *channelNotifier) Start(ctx context.Context) error {
+	return nil
+}
+
+// start starts the channel once.
+func (n *channelNotifier) start(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.started {
+		return nil
+	}
+	n.started = true
+	return n.Notifier.Start(ctx)
+}
+
+// Finish sends the part of the summary routed to the channel. Runs without any of its tasks are
+// not reported, unless the run failed before any task started.
+func (n *channelNotifier) Finish(ctx context.Context, summary Summary) error {
+	s, ok := summary.filter(n.routed)
+	if !ok {
+		return nil
+	}
+	return n.Notifier.Finish(ctx, s)
+}
+
+func (n *channelNotifier) TaskFinished(ctx context.Context, task runner.TaskReport) error {
+	if !n.routed(task.TaskInfo) {
+		return nil
+	}
+	err := n.start(ctx)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	tn, ok := n.Notifier.(taskNotifier)
+	if !ok {
+		return nil
+	}
+	return tn.TaskFinished(ctx, task)
+}
+
+// Progress is passed on once the channel started.
+func (n *channelNotifier) Progress(ctx context.Context, message string) error {
+	p, ok := n.Notifier.(progressNotifier)
+	if !ok {
+		return nil
+	}
+	n.mu.Lock()
+	started := n.started
+	n.mu.Unlock()
+	if !started {
+		return nil
+	}
+	return p.Progress(ctx, message)
+}
+
+// notifyRoutes maps a task to the channels of its resource, looked up by source host and project.
+func notifyRoutes(cfg config.Config) func(runner.TaskInfo) []string {
+	return func(t runner.TaskInfo) []string {
+		kind, name, _ := strings.Cut(t.Resource, "/")
+		switch kind {
+		case "instance":
+			return cfg.ResolveNotify(t.Origin, t.Project, config.RetentionInstances, name)
+		case "volume":
+			_, name, _ = strings.Cut(name, "/") // volume/<pool>/<name>
+			return cfg.ResolveNotify(t.Origin, t.Project, config.RetentionVolumes, name)
+		default:
+			return cfg.ResolveNotify(t.Origin, t.Project, "", "")
+		}
+	}
+}
+
+// filter returns the summary restricted to the tasks keep accepts, with the result recomputed
+// from them. It reports false if no task is kept. Summaries without report are returned as is.
+func (s Summary) filter(keep func(runner.TaskInfo) bool) (Summary, bool) {
+	if s.Report == nil {
+		return s, true
+	}
+
+	r := *s.Report
+	r.Tasks, r.Total, r.Failed, r.Skipped, r.Retries = nil, 0, 0, 0, 0
+	for _, t := range s.Report.Tasks {
+		if !keep(t.TaskInfo) {
+			continue
+		}
+		r.Tasks = append(r.Tasks, t)
+		r.Total++
+		r.Retries += max(t.Attempts-1, 0)
+		switch t.Status {
+		case runner.TaskFailed:
+			r.Failed++
+		case runner.TaskSkipped:
+			r.Skipped++
+		}
+	}
+	if r.Total == 0 {
+		return s, false
+	}
+
+	switch {
+	case r.Failed > 0:
+		s.Result = ResultFailed
+	case r.Skipped > 0 && s.Result == ResultAborted:
+		s.Result = ResultAborted
+	default:
+		s.Result = ResultSuccess
+		s.Err = nil
+	}
+	r.Status = s.Result.String()
+	s.Report = &r
+	return s, true
+}
@@ -0,0 +1,206 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// taskRecorder records the tasks passed to TaskFinished.
+type taskRecorder struct {
+	fakeNotifier
+	tasks []string
+}
+
+func (n *taskRecorder) TaskFinished(ctx context.Context, task runner.TaskReport) error {
+	n.tasks = append(n.tasks, task.Name)
+	return nil
+}
+
+// blockingTasks holds every TaskFinished call until release is closed.
+type blockingTasks struct {
+	taskRecorder
+	release chan struct{}
+}
+
+func (n *blockingTasks) TaskFinished(ctx context.Context, task runner.TaskReport) error {
+	<-n.release
+	return n.taskRecorder.TaskFinished(ctx, task)
+}
+
+func TestManagerSendsTasksAsynchronously(t *testing.T) {
+	n := &blockingTasks{taskRecorder: taskRecorder{fakeNotifier: fakeNotifier{name: "webhook"}}, release: make(chan struct{})}
+	m := newTestManager("", n)
+
+	returned := make(chan struct{})
+	go func() {
+		m.TaskFinished(context.Background(), runner.TaskReport{Name: "copy c1"})
+		m.TaskFinished(context.Background(), runner.TaskReport{Name: "copy c2"})
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("TaskFinished waited for the notifier")
+	}
+
+	close(n.release)
+	err := m.Finish(context.Background(), testSummary(ResultSuccess))
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if len(n.tasks) != 2 || len(n.got) != 1 {
+		t.Fatalf("tasks=%v summaries=%d, want both tasks before the summary", n.tasks, len(n.got))
+	}
+
+	// tasks finishing after the summary are dropped
+	m.TaskFinished(context.Background(), runner.TaskReport{Name: "copy c3"})
+	if len(n.tasks) != 2 {
+		t.Fatalf("tasks=%v", n.tasks)
+	}
+}
+
+func TestNotifyRoutesBySource(t *testing.T) {
+	cfg := config.Config{
+		Hosts: []config.Host{{Name: "site-a", Role: "source"}, {Name: "site-b", Role: "source"}},
+		Projects: []config.Project{
+			{Name: "default", Source: "site-a", Notify: []string{"team-a"}},
+			{Name: "default", Source: "site-b", Notify: []string{"team-b"}},
+		},
+	}
+	routes := notifyRoutes(cfg)
+
+	// the prune on the target reports the target as host, the origin decides the project
+	prune := runner.TaskInfo{Project: "default", Resource: "instance/c1", Host: "nas", Origin: "site-b"}
+	if got := routes(prune); !slices.Equal(got, []string{"team-b"}) {
+		t.Fatalf("site-b prune routed to %v", got)
+	}
+	snap := runner.TaskInfo{Project: "default", Resource: "instance/c1", Host: "site-a", Origin: "site-a"}
+	if got := routes(snap); !slices.Equal(got, []string{"team-a"}) {
+		t.Fatalf("site-a snapshot routed to %v", got)
+	}
+}
+
+func TestManagerRoutesChannels(t *testing.T) {
+	cfg := config.Config{Projects: []config.Project{
+		{
+			Name:      "db",
+			Notify:    []string{"dba"},
+			Instances: []config.Instance{{Name: "pg"}},
+			Volumes:   []config.Volume{{Name: "www", Notify: []string{"web"}}},
+		},
+		{Name: "web", Notify: []string{"web"}},
+		{Name: "misc"},
+	}}
+	routes := notifyRoutes(cfg)
+
+	global := &fakeNotifier{name: "gotify"}
+	dba := &taskRecorder{fakeNotifier: fakeNotifier{name: "gotify"}}
+	web := &taskRecorder{fakeNotifier: fakeNotifier{name: "gotify"}}
+	m := newTestManager("", global,
+		&channelNotifier{Notifier: dba, channel: "dba", routes: routes},
+		&channelNotifier{Notifier: web, channel: "web", routes: routes},
+	)
+
+	tasks := []*runner.TaskReport{
+		{Name: "snapshot pg", TaskInfo: runner.TaskInfo{Project: "db", Resource: "instance/pg"}, Status: runner.TaskFailed, Attempts: 3, Error: "disk full"},
+		{Name: "snapshot www", TaskInfo: runner.TaskInfo{Project: "db", Resource: "volume/local/www"}, Status: runner.TaskSuccess, Attempts: 1},
+		{Name: "snapshot nginx", TaskInfo: runner.TaskInfo{Project: "web", Resource: "instance/nginx"}, Status: runner.TaskSuccess, Attempts: 1},
+		{Name: "snapshot tmp", TaskInfo: runner.TaskInfo{Project: "misc", Resource: "instance/tmp"}, Status: runner.TaskSuccess, Attempts: 1},
+	}
+	for _, task := range tasks {
+		m.TaskFinished(context.Background(), *task)
+	}
+	summary := Summary{
+		Result: ResultFailed,
+		Report: &runner.Report{Status: "failed", Total: 4, Failed: 1, Retries: 2, Tasks: tasks},
+		Err:    errors.New("1 task failed"),
+	}
+	err := m.Finish(context.Background(), summary)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	if len(global.got) != 1 || global.got[0].Report.Total != 4 {
+		t.Fatalf("global notifier should get the whole run, got %+v", global.got)
+	}
+
+	if len(dba.tasks) != 1 || dba.tasks[0] != "snapshot pg" {
+		t.Fatalf("dba tasks=%v", dba.tasks)
+	}
+	if len(dba.got) != 1 {
+		t.Fatalf("dba summaries=%d want 1", len(dba.got))
+	}
+	if s := dba.got[0]; s.Result != ResultFailed || s.Report.Total != 1 || s.Report.Failed != 1 || s.Report.Retries != 2 {
+		t.Fatalf("unexpected dba summary: %+v %+v", s, s.Report)
+	}
+
+	if len(web.tasks) != 2 || len(web.got) != 1 {
+		t.Fatalf("web tasks=%v summaries=%d", web.tasks, len(web.got))
+	}
+	if s := web.got[0]; s.Result != ResultSuccess || s.Err != nil || s.Report.Total != 2 || s.Report.Status != "success" {
+		t.Fatalf("unexpected web summary: %+v %+v", s, s.Report)
+	}
+}
+
+func TestChannelSkipsRunsWithoutItsTasks(t *testing.T) {
+	n := &fakeNotifier{name: "ntfy"}
+	ch := &channelNotifier{Notifier: n, channel: "dba", routes: func(runner.TaskInfo) []string { return nil }}
+
+	err := ch.Finish(context.Background(), testSummary(ResultFailed))
+	if err != nil || len(n.got) != 0 {
+		t.Fatalf("expected no notification, got %d (%v)", len(n.got), err)
+	}
+
+	// a run failing before any task started concerns every channel
+	early := Summary{Result: ResultFailed, Err: errors.New("connect to nas failed")}
+	err = ch.Finish(context.Background(), early)
+	if err != nil || len(n.got) != 1 {
+		t.Fatalf("expected the early failure, got %d (%v)", len(n.got), err)
+	}
+	if ch.Name() != "channel dba: ntfy" {
+		t.Fatalf("name=%q", ch.Name())
+	}
+}
+
+func TestChannelHealthchecks(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	routes := func(t runner.TaskInfo) []string {
+		if t.Project == "db" {
+			return []string{"dba"}
+		}
+		return nil
+	}
+	dba := &channelNotifier{Notifier: NewHealthchecksNotifier(srv.URL + "/dba"), channel: "dba", routes: routes}
+	web := &channelNotifier{Notifier: NewHealthchecksNotifier(srv.URL + "/web"), channel: "web", routes: routes}
+	m := newTestManager("", dba, web)
+	ctx := context.Background()
+
+	m.Start(ctx)
+	task := runner.TaskReport{Name: "snapshot pg", TaskInfo: runner.TaskInfo{Project: "db", Resource: "instance/pg"}, Status: runner.TaskSuccess}
+	m.TaskFinished(ctx, task)
+	m.closeTasks()
+	m.Progress(ctx, "phase snapshot finished")
+	err := m.Finish(ctx, Summary{Result: ResultSuccess, Report: &runner.Report{Status: "success", Total: 1, Tasks: []*runner.TaskReport{&task}}})
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	// the web channel had no task in the run and is neither started nor finished
+	want := []string{"/dba/start", "/dba/log", "/dba/0"}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("pings=%v want %v", paths, want)
+	}
+}
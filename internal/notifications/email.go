@@ -0,0 +1,284 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+)
+
+// Email sends the run summary via SMTP.
+type Email struct {
+	Host     string
+	Port     int
+	TLS      string // starttls, tls (implicit) or none
+	Username string
+	Password string
+	From     string
+	To       map[Result][]string
+	Timeout  time.Duration
+
+	tlsConfig *tls.Config // nil: verify against Host; tests trust their self-signed certificate
+}
+
+type EmailNotifier struct {
+	email *Email
+}
+
+func NewEmailNotifier(cfg config.Email) *EmailNotifier {
+	e := &Email{
+		Host:     strings.TrimSpace(cfg.Host),
+		Port:     cfg.SMTPPort(),
+		TLS:      cfg.TLSMode(),
+		Username: cfg.Username,
+		Password: cfg.Password,
+		From:     cfg.From,
+		To:       make(map[Result][]string),
+		Timeout:  30 * time.Second,
+	}
+	for _, r := range []Result{ResultSuccess, ResultFailed, ResultAborted} {
+		e.To[r] = cfg.RecipientsFor(r.String())
+	}
+	return &EmailNotifier{email: e}
+}
+
+func (n EmailNotifier) Name() string { return "email" }
+
+func (n EmailNotifier) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n EmailNotifier) Finish(ctx context.Context, summary Summary) error {
+	return n.email.Finish(ctx, summary)
+}
+
+// Finish mails the summary to the recipients of its outcome. Outcomes without recipients are not mailed.
+func (e *Email) Finish(ctx context.Context, summary Summary) error {
+	if e == nil || e.Host == "" {
+		return nil
+	}
+	to := e.To[summary.Result]
+	if len(to) == 0 {
+		return nil
+	}
+
+	msg, err := e.message(summary, to, time.Now())
+	if err != nil {
+		return fmt.Errorf("email: build message: %w", err)
+	}
+	err = e.send(ctx, to, msg)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+// message builds a multipart/alternative mail with a plain text and an HTML part.
+func (e *Email) message(summary Summary, to []string, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		render      func(Summary) (string, error)
+	}{
+		{"text/plain; charset=utf-8", emailText},
+		{"text/html; charset=utf-8", emailHTML},
+	} {
+		content, err := part.render(summary)
+		if err != nil {
+			return nil, err
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(content))
+		if err == nil {
+			err = qp.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	subject := summary.Title()
+	if len(summary.Hosts) > 0 {
+		subject += " (" + strings.Join(summary.Hosts, ", ") + ")"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: %s\r\n", e.messageID(now))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func (e *Email) messageID(now time.Time) string {
+	domain := "localhost"
+	if from, err := mail.ParseAddress(e.From); err == nil {
+		if _, d, ok := strings.Cut(from.Address, "@"); ok {
+			domain = d
+		}
+	}
+	return fmt.Sprintf("<iab.%d.%s@%s>", now.UnixNano(), rand.Text(), domain)
+}
+
+func (e *Email) send(ctx context.Context, to []string, msg []byte) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := e.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: e.Host}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if e.TLS == config.EmailTLSImplicit {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if e.TLS == config.EmailTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if e.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host))
+		if err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", e.From, err)
+	}
+	err = c.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		addr, err := mail.ParseAddress(rcpt)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", rcpt, err)
+		}
+		err = c.Rcpt(addr.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailText is the plain text part: the summary followed by the status of every resource.
+func emailText(s Summary) (string, error) {
+	var b strings.Builder
+	b.WriteString(s.Text())
+
+	resources := s.Resources()
+	if len(resources) == 0 {
+		return b.String(), nil
+	}
+
+	b.WriteString("\nResources:\n")
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tRESOURCE\tSTATUS\tDURATION\tSNAPSHOT\tERROR")
+	for _, r := range resources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Project, r.Resource, r.Status, r.Duration, r.Snapshot, strings.Join(r.Errors, "; "))
+	}
+	err := tw.Flush()
+	return b.String(), err
+}
+
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<p>Duration: {{.Duration}}{{if .Hosts}}<br>Hosts: {{range $i, $h := .Hosts}}{{if $i}}, {{end}}{{$h}}{{end}}{{end}}</p>
+{{with .Report}}<p>Tasks: {{.Total}} total, {{.Failed}} failed, {{.Skipped}} skipped, {{.Retries}} retries</p>{{end}}
+{{if .Resources}}
+<table cellpadding="4" cellspacing="0" border="1" style="border-collapse: collapse">
+<tr><th>Project</th><th>Resource</th><th>Status</th><th>Duration</th><th>Snapshot</th><th>Error</th></tr>
+{{range .Resources}}<tr>
+<td>{{.Project}}</td><td>{{.Resource}}</td>
+<td style="color: {{if eq .Status "success"}}green{{else if eq .Status "failed"}}red{{else}}gray{{end}}">{{.Status}}</td>
+<td>{{.Duration}}</td><td>{{.Snapshot}}</td><td>{{range .Errors}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{else if .Err}}<p>Error: {{.Err}}</p>{{end}}
+</body></html>
+`))
+
+func emailHTML(s Summary) (string, error) {
+	var b strings.Builder
+	err := emailTemplate.Execute(&b, struct {
+		Summary
+		Title     string
+		Duration  time.Duration
+		Resources []ResourceOutcome
+	}{s, s.Title(), s.Duration(), s.Resources()})
+	return b.String(), err
+}
@@ -0,0 +1,276 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+)
+
+// smtpSession is what the SMTP stand-in received.
+type smtpSession struct {
+	tls  bool // the session was encrypted when the mail was sent
+	auth string
+	from string
+	rcpt []string
+	data string
+}
+
+// serveSMTP accepts one SMTP session on a local listener and returns its address.
+// With a tlsConfig the listener speaks mode, config.EmailTLSImplicit or config.EmailTLSStartTLS.
+func serveSMTP(t *testing.T, mode string, tlsConfig *tls.Config) (string, <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	if mode == config.EmailTLSImplicit {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	done := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		s := smtpSession{tls: mode == config.EmailTLSImplicit}
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.Fields(cmd + " ")[0]); verb {
+			case "EHLO":
+				reply("250-localhost")
+				if mode == config.EmailTLSStartTLS && !s.tls {
+					reply("250-STARTTLS")
+				}
+				reply("250 AUTH PLAIN")
+			case "STARTTLS":
+				reply("220 ready")
+				tlsConn := tls.Server(conn, tlsConfig)
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				conn, r, s.tls = tlsConn, bufio.NewReader(tlsConn), true
+			case "AUTH":
+				s.auth = cmd
+				reply("235 ok")
+			case "MAIL":
+				s.from = cmd
+				reply("250 ok")
+			case "RCPT":
+				s.rcpt = append(s.rcpt, cmd)
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				s.data = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				done <- s
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	}()
+	return ln.Addr().String(), done
+}
+
+func TestEmailFinish(t *testing.T) {
+	addr, sessions := serveSMTP(t, config.EmailTLSNone, nil)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	n := NewEmailNotifier(config.Email{
+		Host:     host,
+		Port:     p,
+		TLS:      config.EmailTLSNone,
+		Username: "iab",
+		Password: "secret",
+		From:     "IAB <iab@example.org>",
+		To:       []string{"audit@example.org"},
+		Recipients: map[string][]string{
+			"failed": {"audit@example.org", "Ops <ops@example.org>"},
+		},
+	})
+
+	err := n.Finish(context.Background(), testSummary(ResultFailed))
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	s := <-sessions
+
+	creds, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(s.auth, "AUTH PLAIN "))
+	if string(creds) != "\x00iab\x00secret" {
+		t.Fatalf("unexpected auth %q", s.auth)
+	}
+	if s.from != "MAIL FROM:<iab@example.org>" || len(s.rcpt) != 2 || s.rcpt[1] != "RCPT TO:<ops@example.org>" {
+		t.Fatalf("unexpected envelope: %q %q", s.from, s.rcpt)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "IAB Backup failed (src, nas)" {
+		t.Fatalf("subject=%q", subject)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasPrefix(id, "<iab.") || !strings.HasSuffix(id, "@example.org>") {
+		t.Fatalf("message id=%q", id)
+	}
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("content type: %v", err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	parts := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := io.ReadAll(part) // quoted-printable is decoded by the reader
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[mediaType] = string(b)
+	}
+
+	if text := parts["text/plain"]; !strings.Contains(text, "copy c1 src->nas") || !strings.Contains(text, "PROJECT") {
+		t.Fatalf("unexpected text part:\n%s", text)
+	}
+	if html := parts["text/html"]; !strings.Contains(html, "<table") || !strings.Contains(html, "connection reset") {
+		t.Fatalf("unexpected html part:\n%s", html)
+	}
+}
+
+func TestEmailSkipsOutcomeWithoutRecipients(t *testing.T) {
+	n := NewEmailNotifier(config.Email{
+		Host:       "127.0.0.1",
+		Port:       1, // never dialed
+		From:       "iab@example.org",
+		Recipients: map[string][]string{"failed": {"ops@example.org"}},
+	})
+	err := n.Finish(context.Background(), testSummary(ResultSuccess))
+	if err != nil {
+		t.Fatalf("expected no mail for success, got %v", err)
+	}
+}
+
+// selfSignedTLS returns a server config with a certificate for 127.0.0.1 and a client config trusting it.
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	return server, client
+}
+
+func TestEmailFinish_TLS(t *testing.T) {
+	for _, mode := range []string{config.EmailTLSStartTLS, config.EmailTLSImplicit} {
+		t.Run(mode, func(t *testing.T) {
+			serverTLS, clientTLS := selfSignedTLS(t)
+			addr, sessions := serveSMTP(t, mode, serverTLS)
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+
+			n := NewEmailNotifier(config.Email{
+				Host:     host,
+				Port:     p,
+				TLS:      mode,
+				Username: "iab",
+				Password: "secret",
+				From:     "iab@example.org",
+				To:       []string{"ops@example.org"},
+			})
+			n.email.tlsConfig = clientTLS
+
+			err := n.Finish(context.Background(), testSummary(ResultFailed))
+			if err != nil {
+				t.Fatalf("finish: %v", err)
+			}
+			s := <-sessions
+			if !s.tls || s.auth == "" || len(s.rcpt) != 1 {
+				t.Fatalf("unexpected session: tls=%v auth=%q rcpt=%q", s.tls, s.auth, s.rcpt)
+			}
+		})
+	}
+}
+
+func TestEmailFinish_TLSRejectsUntrustedCert(t *testing.T) {
+	serverTLS, _ := selfSignedTLS(t)
+	addr, _ := serveSMTP(t, config.EmailTLSImplicit, serverTLS)
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	n := NewEmailNotifier(config.Email{
+		Host: host,
+		Port: p,
+		TLS:  config.EmailTLSImplicit,
+		From: "iab@example.org",
+		To:   []string{"ops@example.org"},
+	})
+	err := n.Finish(context.Background(), testSummary(ResultFailed))
+	if err == nil {
+		t.Fatal("expected a certificate error")
+	}
+}
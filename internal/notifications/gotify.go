@@ -9,18 +9,37 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+)
+
+// Default Gotify priorities, see https://gotify.net/docs/msgextras for how clients map them.
+const (
+	DefaultGotifyFailurePriority = 5
+	DefaultGotifySuccessPriority = 2
 )
 
 type Gotify struct {
-	Client  *http.Client
-	PingURL string
+	Client          *http.Client
+	PingURL         string
+	NotifyOnSuccess bool
+	SuccessPriority int
+	FailurePriority int
 }
 type GotifyNotifier struct {
 	gty *Gotify
 }
 
-func NewGotifyNotifier(pingURL string) *GotifyNotifier {
-	return &GotifyNotifier{gty: NewGotify(pingURL)}
+func NewGotifyNotifier(pingURL string, cfg config.Gotify) *GotifyNotifier {
+	g := NewGotify(pingURL)
+	g.NotifyOnSuccess = cfg.NotifyOnSuccess
+	if cfg.SuccessPriority != nil {
+		g.SuccessPriority = *cfg.SuccessPriority
+	}
+	if cfg.FailurePriority != nil {
+		g.FailurePriority = *cfg.FailurePriority
+	}
+	return &GotifyNotifier{gty: g}
 }
 
 func (n GotifyNotifier) Name() string { return "gotify" }
@@ -29,8 +48,8 @@ func (n GotifyNotifier) Start(ctx context.Context) error {
 	return nil
 }
 
-func (n GotifyNotifier) Finish(ctx context.Context, ok bool) error {
-	return n.gty.Finish(ctx, ok)
+func (n GotifyNotifier) Finish(ctx context.Context, summary Summary) error {
+	return n.gty.Finish(ctx, summary)
 }
 
 func NewGotify(pingURL string) *Gotify {
@@ -38,55 +57,65 @@ func NewGotify(pingURL string) *Gotify {
 		Client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		PingURL: strings.TrimSpace(pingURL),
+		PingURL:         strings.TrimSpace(pingURL),
+		SuccessPriority: DefaultGotifySuccessPriority,
+		FailurePriority: DefaultGotifyFailurePriority,
 	}
 }
 
-func (g *Gotify) Finish(ctx context.Context, ok bool) error {
+// gotifyMessage is the body of POST /message. The markdown extra makes clients render the message.
+type gotifyMessage struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+// Finish sends the summary of the run. Successful runs are only sent with NotifyOnSuccess.
+func (g *Gotify) Finish(ctx context.Context, summary Summary) error {
 	if g == nil || g.Client == nil {
 		return nil
 	}
 	if g.PingURL == "" {
 		return nil
 	}
+	if summary.Result == ResultSuccess && !g.NotifyOnSuccess {
+		return nil
+	}
+
+	priority := g.FailurePriority
+	if summary.Result == ResultSuccess {
+		priority = g.SuccessPriority
+	}
+
+	msg, err := json.Marshal(gotifyMessage{
+		Title:    summary.Title(),
+		Message:  summary.Markdown(),
+		Priority: priority,
+		Extras: map[string]any{
+			"client::display": map[string]string{"contentType": "text/markdown"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("gotify: marshal payload error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.PingURL, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-	if !ok {
-		title := "IAB Backup failed"
-		message := "Backup run finished with errors"
-		priority := 5
-
-		payload := struct {
-			Title    string `json:"title"`
-			Message  string `json:"message"`
-			Priority int    `json:"priority"`
-		}{
-			Title:    title,
-			Message:  message,
-			Priority: priority,
-		}
-
-		msg, err := json.Marshal(payload)
-		if err != nil {
-			return fmt.Errorf("gotify: marshal payload error: %w", err)
-		}
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.PingURL, bytes.NewReader(msg))
-		if err != nil {
-			return err
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := g.Client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode >= 300 {
-			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-			return fmt.Errorf("gotify: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
-		}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("gotify: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
 	}
 	return nil
 }
@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+func testSummary(result Result) Summary {
+	start := time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+	return Summary{
+		Result: result,
+		Start:  start,
+		End:    start.Add(754 * time.Second),
+		Hosts:  []string{"src", "nas"},
+		Report: &runner.Report{
+			Retries: 1,
+			Tasks: []*runner.TaskReport{
+				{Name: "snapshot c1", Kind: runner.KindSnapshot, Status: runner.TaskSuccess, SnapshotsCreated: []string{"IAB_20260301-030000"}},
+				{Name: "copy c1 src->nas", Kind: runner.KindCopy, Status: runner.TaskFailed, Error: "connection reset"},
+				{Name: "prune c1 src", Kind: runner.KindPrune, TaskInfo: runner.TaskInfo{Role: "source"}, Status: runner.TaskSuccess, SnapshotsPruned: []string{"IAB_a", "IAB_b"}},
+			},
+		},
+		Err: errors.New("task failed"),
+	}
+}
+
+func TestSummaryMarkdown(t *testing.T) {
+	md := testSummary(ResultFailed).Markdown()
+	for _, want := range []string{
+		"finished with errors after **12m34s** on src, nas",
+		"- Snapshots created: 1",
+		"- Copies: 0 of 1",
+		"- Snapshots pruned: 2 (source 2)",
+		"**Failed tasks (1)**",
+		"- `copy c1 src->nas`: connection reset",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("missing %q in:\n%s", want, md)
+		}
+	}
+
+	early := Summary{Result: ResultFailed, Err: errors.New("connect to nas failed")}
+	if md := early.Markdown(); !strings.Contains(md, "connect to nas failed") {
+		t.Errorf("run error missing in:\n%s", md)
+	}
+}
+
+func TestGotifyFinish(t *testing.T) {
+	var got []gotifyMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg gotifyMessage
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		got = append(got, msg)
+	}))
+	defer srv.Close()
+
+	prio := 1
+	n := NewGotifyNotifier(srv.URL, config.Gotify{})
+	ctx := context.Background()
+
+	// success is not sent by default
+	if err := n.Finish(ctx, testSummary(ResultSuccess)); err != nil || len(got) != 0 {
+		t.Fatalf("unexpected success message: %v %v", got, err)
+	}
+	if err := n.Finish(ctx, testSummary(ResultFailed)); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	n = NewGotifyNotifier(srv.URL, config.Gotify{NotifyOnSuccess: true, SuccessPriority: &prio})
+	if err := n.Finish(ctx, testSummary(ResultSuccess)); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("messages=%d want 2", len(got))
+	}
+	failed, success := got[0], got[1]
+	if failed.Title != "IAB Backup failed" || failed.Priority != DefaultGotifyFailurePriority {
+		t.Fatalf("unexpected failure message: %+v", failed)
+	}
+	display, _ := failed.Extras["client::display"].(map[string]any)
+	if display["contentType"] != "text/markdown" {
+		t.Fatalf("missing markdown extra: %v", failed.Extras)
+	}
+	if success.Title != "IAB Backup succeeded" || success.Priority != 1 {
+		t.Fatalf("unexpected success message: %+v", success)
+	}
+}
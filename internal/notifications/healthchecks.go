@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type Healthchecks struct {
@@ -27,12 +28,18 @@ func (n *HealthchecksNotifier) Start(ctx context.Context) error {
 	return n.hc.Start(ctx)
 }
 
-func (n *HealthchecksNotifier) Finish(ctx context.Context, ok bool) error {
+// Finish signals success or failure, the summary of the run is shown as the ping body.
+func (n *HealthchecksNotifier) Finish(ctx context.Context, summary Summary) error {
 	status := 0
-	if !ok {
+	if summary.Result != ResultSuccess {
 		status = 1
 	}
-	return n.hc.Status(ctx, status)
+	return n.hc.Status(ctx, status, summary.Text())
+}
+
+// Progress adds a log entry to the check without changing its state.
+func (n *HealthchecksNotifier) Progress(ctx context.Context, message string) error {
+	return n.hc.Log(ctx, message)
 }
 
 func NewHealthchecks(pingURL string) *Healthchecks {
@@ -44,18 +51,27 @@ func NewHealthchecks(pingURL string) *Healthchecks {
 	}
 }
 
+// maxPingBody is the size limit of a ping body, Healthchecks ignores everything beyond.
+const maxPingBody = 100_000
+
 func (h *Healthchecks) Start(ctx context.Context) error {
-	return h.ping(ctx, "/start")
+	return h.ping(ctx, "/start", "")
 }
 
-func (h *Healthchecks) Status(ctx context.Context, status int) error {
+// Status pings /0 (success) or /1 (failure) with body shown in the Healthchecks UI.
+func (h *Healthchecks) Status(ctx context.Context, status int, body string) error {
 	if status != 0 && status != 1 {
 		return fmt.Errorf("healthchecks: invalid status %d (expected 0 or 1)", status)
 	}
-	return h.ping(ctx, fmt.Sprintf("/%d", status))
+	return h.ping(ctx, fmt.Sprintf("/%d", status), body)
 }
 
-func (h *Healthchecks) ping(ctx context.Context, suffix string) error {
+// Log pings /log, which records body as an event without changing the state of the check.
+func (h *Healthchecks) Log(ctx context.Context, body string) error {
+	return h.ping(ctx, "/log", body)
+}
+
+func (h *Healthchecks) ping(ctx context.Context, suffix, body string) error {
 	if h == nil || h.Client == nil {
 		return nil
 	}
@@ -63,10 +79,20 @@ func (h *Healthchecks) ping(ctx context.Context, suffix string) error {
 		return nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.PingURL+suffix, nil)
+	if len(body) > maxPingBody {
+		const marker = "\n[truncated]"
+		i := maxPingBody - len(marker)
+		for i > 0 && !utf8.RuneStart(body[i]) {
+			i-- // do not split a multi-byte rune
+		}
+		body = body[:i] + marker
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.PingURL+suffix, strings.NewReader(body))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
 
 	resp, err := h.Client.Do(req)
 	if err != nil {
@@ -0,0 +1,76 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+type ping struct {
+	method, path, body string
+}
+
+func TestHealthchecksPings(t *testing.T) {
+	var pings []ping
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		pings = append(pings, ping{r.Method, r.URL.Path, string(b)})
+	}))
+	defer srv.Close()
+
+	n := NewHealthchecksNotifier(srv.URL + "/ping/uuid/")
+	ctx := context.Background()
+	for _, err := range []error{
+		n.Start(ctx),
+		n.Progress(ctx, "phase snapshot finished"),
+		n.Finish(ctx, testSummary(ResultFailed)),
+	} {
+		if err != nil {
+			t.Fatalf("ping: %v", err)
+		}
+	}
+
+	if len(pings) != 3 {
+		t.Fatalf("pings=%d want 3", len(pings))
+	}
+	if pings[0].path != "/ping/uuid/start" || pings[1].path != "/ping/uuid/log" || pings[2].path != "/ping/uuid/1" {
+		t.Fatalf("unexpected paths: %+v", pings)
+	}
+	if pings[1].method != http.MethodPost || pings[1].body != "phase snapshot finished" {
+		t.Fatalf("unexpected log ping: %+v", pings[1])
+	}
+	for _, want := range []string{"IAB Backup failed", "Duration: 12m34s", "copy c1 src->nas", "connection reset"} {
+		if !strings.Contains(pings[2].body, want) {
+			t.Errorf("missing %q in body:\n%s", want, pings[2].body)
+		}
+	}
+}
+
+func TestHealthchecksTruncatesBody(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	err := NewHealthchecks(srv.URL).Status(context.Background(), 1, strings.Repeat("x", 2*maxPingBody))
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(body) != maxPingBody {
+		t.Fatalf("body size=%d want %d", len(body), maxPingBody)
+	}
+
+	// the cut falls into a multi-byte rune
+	err = NewHealthchecks(srv.URL).Status(context.Background(), 1, "x"+strings.Repeat("ä", maxPingBody))
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(body) > maxPingBody || !utf8.Valid(body) || !strings.HasSuffix(string(body), "ä\n[truncated]") {
+		t.Fatalf("body of %d bytes is not truncated on a rune boundary: %q", len(body), body[len(body)-20:])
+	}
+}
@@ -3,54 +3,306 @@ package notifications
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
 )
 
+// Result is the outcome of a backup run.
+type Result int
+
+const (
+	ResultSuccess Result = iota
+	ResultFailed
+	ResultAborted // cancelled before all tasks ran, e.g. by SIGTERM
+)
+
+func (r Result) String() string {
+	switch r {
+	case ResultSuccess:
+		return "success"
+	case ResultFailed:
+		return "failed"
+	case ResultAborted:
+		return "aborted"
+	default:
+		return "unknown"
+	}
+}
+
 type Notifier interface {
 	Name() string
 	Start(ctx context.Context) error
-	Finish(ctx context.Context, ok bool) error
+	Finish(ctx context.Context, summary Summary) error
+}
+
+// progressNotifier is implemented by notifiers which can report progress during a run.
+type progressNotifier interface {
+	Progress(ctx context.Context, message string) error
+}
+
+// taskNotifier is implemented by notifiers which report single tasks.
+type taskNotifier interface {
+	TaskFinished(ctx context.Context, task runner.TaskReport) error
 }
 
+// ErrDelivery is returned by Manager.Finish if a notification could not be delivered.
+var ErrDelivery = errors.New("notification delivery failed")
+
 type Manager struct {
 	logger    *slog.Logger
 	notifiers []Notifier
+
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	spoolDir   string // empty: undelivered notifications are dropped
+
+	// finished tasks are sent by a single goroutine, so a slow notifier does not hold a worker
+	tasksMu     sync.Mutex
+	tasks       chan taskEvent // nil until the first task finished
+	tasksDone   chan struct{}
+	tasksClosed bool
 }
 
-func NewManagerFromConfig(logger *slog.Logger, cfg config.Config) *Manager {
-	m := &Manager{logger: logger}
+// taskQueueSize bounds the finished tasks waiting to be sent, further tasks are dropped.
+const taskQueueSize = 256
+
+type taskEvent struct {
+	ctx  context.Context
+	task runner.TaskReport
+}
+
+// NewManagerFromConfig creates the notifiers configured in cfg, including those of its channels.
+func NewManagerFromConfig(logger *slog.Logger, cfg config.Config) (*Manager, error) {
+	backoff, maxBackoff, err := cfg.IAB.Notifications.Backoffs()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manager{
+		logger:     logger,
+		attempts:   cfg.IAB.Notifications.AttemptCount(),
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		spoolDir:   cfg.IAB.Notifications.SpoolPath(),
+	}
+
+	var hosts []string
+	for _, h := range cfg.Hosts {
+		hosts = append(hosts, h.Name)
+	}
+	m.notifiers, err = newNotifiers(cfg.IAB.Notifiers, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := notifyRoutes(cfg)
+	for _, ch := range cfg.IAB.Channels {
+		notifiers, err := newNotifiers(ch.Notifiers, hosts)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+		for _, n := range notifiers {
+			m.notifiers = append(m.notifiers, &channelNotifier{Notifier: n, channel: ch.Name, routes: routes})
+		}
+	}
+	return m, nil
+}
 
-	if cfg.IAB.HealthchecksURL != "" {
-		m.notifiers = append(m.notifiers, NewHealthchecksNotifier(cfg.IAB.HealthchecksURL))
+// newNotifiers creates the notifiers configured in cfg. hosts are the names of all hosts of the run.
+func newNotifiers(cfg config.Notifiers, hosts []string) ([]Notifier, error) {
+	var notifiers []Notifier
+	if cfg.HealthchecksURL != "" {
+		notifiers = append(notifiers, NewHealthchecksNotifier(cfg.HealthchecksURL))
+	}
+	if cfg.GotifyURL != "" {
+		notifiers = append(notifiers, NewGotifyNotifier(cfg.GotifyURL, cfg.Gotify))
+	}
+	if cfg.Ntfy.URL != "" {
+		notifiers = append(notifiers, NewNtfyNotifier(cfg.Ntfy))
 	}
-	if cfg.IAB.GotifyURL != "" {
-		m.notifiers = append(m.notifiers, NewGotifyNotifier(cfg.IAB.GotifyURL))
+	if cfg.Email.Host != "" {
+		notifiers = append(notifiers, NewEmailNotifier(cfg.Email))
 	}
-	return m
+	for _, wh := range cfg.Webhooks {
+		n, err := NewWebhookNotifier(wh, hosts)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
 }
 
 func (m *Manager) Start(ctx context.Context) {
 	for _, n := range m.notifiers {
-		err := n.Start(ctx)
+		err := m.deliver(ctx, n, "start", func() error { return n.Start(ctx) })
 		if err != nil {
 			m.logger.Warn("notification start failed", "notifier", n.Name(), "error", err)
 		}
 	}
 }
 
-func (m *Manager) Finish(ctx context.Context, ok bool) error {
-	var errs []error
+// deliver calls send until it succeeds or all attempts failed, doubling the delay between attempts.
+func (m *Manager) deliver(ctx context.Context, n Notifier, event string, send func() error) error {
+	delay := m.backoff
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil || attempt >= m.attempts {
+			return err
+		}
+
+		m.logger.Warn("notification failed, retrying",
+			"notifier", n.Name(),
+			"event", event,
+			"attempt", attempt,
+			"attempts", m.attempts,
+			"retryIn", delay,
+			"error", err,
+		)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay = min(delay*2, m.maxBackoff)
+	}
+}
+
+// Flush sends the notifications spooled by earlier runs. Entries are removed once delivered,
+// when their notifier is no longer configured or when they are older than a week.
+func (m *Manager) Flush(ctx context.Context) {
+	if m.spoolDir == "" {
+		return
+	}
+	entries, errs := readSpool(m.spoolDir)
+	for _, err := range errs {
+		m.logger.Warn("failed to read notification spool", "error", err)
+	}
+
+	for _, e := range entries {
+		i := slices.IndexFunc(m.notifiers, func(n Notifier) bool { return n.Name() == e.Notifier })
+		switch {
+		case i < 0:
+			m.logger.Warn("dropping spooled notification, notifier not configured", "notifier", e.Notifier, "created", e.Created)
+		case time.Since(e.Created) > spoolMaxAge:
+			m.logger.Warn("dropping expired spooled notification", "notifier", e.Notifier, "created", e.Created)
+		default:
+			err := m.notifiers[i].Finish(ctx, e.summary())
+			if err != nil {
+				m.logger.Warn("spooled notification still undeliverable", "notifier", e.Notifier, "created", e.Created, "error", err)
+				continue
+			}
+			m.logger.Info("delivered spooled notification", "notifier", e.Notifier, "created", e.Created)
+		}
+
+		err := os.Remove(e.path)
+		if err != nil {
+			m.logger.Warn("failed to remove spooled notification", "path", e.path, "error", err)
+		}
+	}
+}
+
+// Progress passes a progress message to all notifiers supporting it. Failures are only logged.
+func (m *Manager) Progress(ctx context.Context, message string) {
 	for _, n := range m.notifiers {
-		err := n.Finish(ctx, ok)
+		p, ok := n.(progressNotifier)
+		if !ok {
+			continue
+		}
+		err := p.Progress(ctx, message)
 		if err != nil {
-			m.logger.Warn("notification finish failed", "notifier", n.Name(), "error", err)
-			errs = append(errs, err)
+			m.logger.Warn("notification progress failed", "notifier", n.Name(), "error", err)
+		}
+	}
+}
+
+// TaskFinished queues a finished task for all notifiers supporting it and returns without waiting
+// for the delivery. Failures are only logged. It is called concurrently by the workers of the runner.
+func (m *Manager) TaskFinished(ctx context.Context, task runner.TaskReport) {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
+	if m.tasksClosed {
+		m.logger.Warn("task notification after the run finished, dropping it", "task", task.Name)
+		return
+	}
+	if m.tasks == nil {
+		m.tasks = make(chan taskEvent, taskQueueSize)
+		m.tasksDone = make(chan struct{})
+		go m.sendTasks()
+	}
+
+	select {
+	case m.tasks <- taskEvent{ctx: ctx, task: task}:
+	default:
+		m.logger.Warn("task notification queue full, dropping task", "task", task.Name)
+	}
+}
+
+// sendTasks passes the queued tasks to the notifiers until the queue is closed.
+func (m *Manager) sendTasks() {
+	defer close(m.tasksDone)
+	for e := range m.tasks {
+		for _, n := range m.notifiers {
+			tn, ok := n.(taskNotifier)
+			if !ok {
+				continue
+			}
+			err := tn.TaskFinished(e.ctx, e.task)
+			if err != nil {
+				m.logger.Warn("task notification failed", "notifier", n.Name(), "task", e.task.Name, "error", err)
+			}
+		}
+	}
+}
+
+// closeTasks stops accepting finished tasks and waits until the queued ones are sent.
+func (m *Manager) closeTasks() {
+	m.tasksMu.Lock()
+	if m.tasks != nil && !m.tasksClosed {
+		close(m.tasks)
+	}
+	m.tasksClosed = true
+	done := m.tasksDone
+	m.tasksMu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Finish sends the summary to all notifiers. Notifications which fail after all attempts are
+// spooled for the next run, the returned error then wraps ErrDelivery. Queued tasks are sent first.
+func (m *Manager) Finish(ctx context.Context, summary Summary) error {
+	m.closeTasks()
+
+	var errs []error
+	for _, n := range m.notifiers {
+		err := m.deliver(ctx, n, "finish", func() error { return n.Finish(ctx, summary) })
+		if err == nil {
+			continue
+		}
+		m.logger.Error("notification finish failed", "notifier", n.Name(), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", n.Name(), err))
+
+		if m.spoolDir == "" {
+			continue
+		}
+		spoolErr := writeSpool(m.spoolDir, newSpoolEntry(n.Name(), summary, time.Now()))
+		if spoolErr != nil {
+			m.logger.Error("failed to spool notification", "notifier", n.Name(), "error", spoolErr)
+		} else {
+			m.logger.Warn("notification spooled for the next run", "notifier", n.Name(), "dir", m.spoolDir)
 		}
 	}
 	if len(errs) > 0 {
-		return errors.Join(errs...)
+		return fmt.Errorf("%w: %w", ErrDelivery, errors.Join(errs...))
 	}
 	return nil
 }
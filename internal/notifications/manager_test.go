@@ -0,0 +1,108 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeNotifier fails the first failures calls of Finish.
+type fakeNotifier struct {
+	name     string
+	failures int
+	calls    int
+	got      []Summary
+}
+
+func (n *fakeNotifier) Name() string                    { return n.name }
+func (n *fakeNotifier) Start(ctx context.Context) error { return nil }
+func (n *fakeNotifier) Finish(ctx context.Context, s Summary) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("gotify unreachable")
+	}
+	n.got = append(n.got, s)
+	return nil
+}
+
+func newTestManager(dir string, notifiers ...Notifier) *Manager {
+	return &Manager{
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		notifiers:  notifiers,
+		attempts:   3,
+		backoff:    time.Millisecond,
+		maxBackoff: time.Millisecond,
+		spoolDir:   dir,
+	}
+}
+
+func TestManagerRetries(t *testing.T) {
+	dir := t.TempDir()
+	n := &fakeNotifier{name: "gotify", failures: 2}
+
+	err := newTestManager(dir, n).Finish(context.Background(), testSummary(ResultFailed))
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if n.calls != 3 || len(n.got) != 1 {
+		t.Fatalf("calls=%d delivered=%d want 3 and 1", n.calls, len(n.got))
+	}
+	if entries, _ := readSpool(dir); len(entries) != 0 {
+		t.Fatalf("unexpected spool entries: %d", len(entries))
+	}
+}
+
+func TestManagerSpoolsAndFlushes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	down := &fakeNotifier{name: "gotify", failures: 3}
+	up := &fakeNotifier{name: "ntfy"}
+
+	err := newTestManager(dir, down, up).Finish(context.Background(), testSummary(ResultFailed))
+	if !errors.Is(err, ErrDelivery) {
+		t.Fatalf("expected ErrDelivery, got %v", err)
+	}
+	entries, errs := readSpool(dir)
+	if len(errs) != 0 || len(entries) != 1 || entries[0].Notifier != "gotify" {
+		t.Fatalf("unexpected spool: %+v %v", entries, errs)
+	}
+
+	// the next run delivers the spooled notification before its own
+	next := &fakeNotifier{name: "gotify"}
+	newTestManager(dir, next).Flush(context.Background())
+
+	if len(next.got) != 1 {
+		t.Fatalf("delivered=%d want 1", len(next.got))
+	}
+	s := next.got[0]
+	if !s.Delayed || s.Title() != "IAB Backup failed (delayed)" || s.Err == nil || len(s.FailedTasks()) != 1 {
+		t.Fatalf("unexpected spooled summary: %+v", s)
+	}
+	if entries, _ := readSpool(dir); len(entries) != 0 {
+		t.Fatalf("spool not emptied: %d", len(entries))
+	}
+}
+
+func TestManagerFlushKeepsUndelivered(t *testing.T) {
+	dir := t.TempDir()
+	old := testSummary(ResultFailed)
+	_ = writeSpool(dir, newSpoolEntry("gotify", old, time.Now()))
+	_ = writeSpool(dir, newSpoolEntry("gotify", old, time.Now().Add(-8*24*time.Hour)))
+	_ = writeSpool(dir, newSpoolEntry("removed", old, time.Now()))
+
+	n := &fakeNotifier{name: "gotify", failures: 1}
+	newTestManager(dir, n).Flush(context.Background())
+
+	// the expired and the orphaned entries are dropped, the failed one is kept
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(paths) != 1 || n.calls != 1 {
+		t.Fatalf("files=%d calls=%d want 1 and 1", len(paths), n.calls)
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+)
+
+// Default ntfy priorities, 1 (min) to 5 (max).
+const (
+	DefaultNtfyFailurePriority = 4
+	DefaultNtfySuccessPriority = 2
+)
+
+// outcomeTags are shown as emoji by ntfy clients.
+var outcomeTags = map[Result]string{
+	ResultSuccess: "white_check_mark",
+	ResultFailed:  "rotating_light",
+	ResultAborted: "warning",
+}
+
+// Ntfy publishes run summaries to an ntfy topic.
+type Ntfy struct {
+	Client          *http.Client
+	TopicURL        string
+	Token           string
+	Tags            []string
+	NotifyOnSuccess bool
+	SuccessPriority int
+	FailurePriority int
+}
+
+type NtfyNotifier struct {
+	ntfy *Ntfy
+}
+
+func NewNtfyNotifier(cfg config.Ntfy) *NtfyNotifier {
+	n := NewNtfy(cfg.URL)
+	n.Token = strings.TrimSpace(cfg.Token)
+	n.Tags = cfg.Tags
+	n.NotifyOnSuccess = cfg.NotifyOnSuccess
+	if cfg.SuccessPriority != nil {
+		n.SuccessPriority = *cfg.SuccessPriority
+	}
+	if cfg.FailurePriority != nil {
+		n.FailurePriority = *cfg.FailurePriority
+	}
+	return &NtfyNotifier{ntfy: n}
+}
+
+func (n NtfyNotifier) Name() string { return "ntfy" }
+
+func (n NtfyNotifier) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n NtfyNotifier) Finish(ctx context.Context, summary Summary) error {
+	return n.ntfy.Finish(ctx, summary)
+}
+
+func NewNtfy(topicURL string) *Ntfy {
+	return &Ntfy{
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		TopicURL:        strings.TrimSpace(topicURL),
+		SuccessPriority: DefaultNtfySuccessPriority,
+		FailurePriority: DefaultNtfyFailurePriority,
+	}
+}
+
+// Finish publishes the summary of the run as markdown. Successful runs are only sent with NotifyOnSuccess.
+func (n *Ntfy) Finish(ctx context.Context, summary Summary) error {
+	if n == nil || n.Client == nil {
+		return nil
+	}
+	if n.TopicURL == "" {
+		return nil
+	}
+	if summary.Result == ResultSuccess && !n.NotifyOnSuccess {
+		return nil
+	}
+
+	priority := n.FailurePriority
+	if summary.Result == ResultSuccess {
+		priority = n.SuccessPriority
+	}
+	tags := append([]string{outcomeTags[summary.Result]}, n.Tags...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.TopicURL, strings.NewReader(summary.Markdown()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", summary.Title())
+	req.Header.Set("Priority", strconv.Itoa(priority))
+	req.Header.Set("Tags", strings.Join(tags, ","))
+	req.Header.Set("Markdown", "yes")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("ntfy: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
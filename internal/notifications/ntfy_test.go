@@ -0,0 +1,52 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+)
+
+func TestNtfyFinish(t *testing.T) {
+	var reqs []*http.Request
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		reqs, bodies = append(reqs, r), append(bodies, string(b))
+	}))
+	defer srv.Close()
+
+	prio := 5
+	n := NewNtfyNotifier(config.Ntfy{URL: srv.URL + "/backups", Token: "tk_secret", Tags: []string{"iab"}, FailurePriority: &prio})
+	ctx := context.Background()
+
+	if err := n.Finish(ctx, testSummary(ResultSuccess)); err != nil || len(reqs) != 0 {
+		t.Fatalf("unexpected success message: %d %v", len(reqs), err)
+	}
+	if err := n.Finish(ctx, testSummary(ResultAborted)); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("requests=%d want 1", len(reqs))
+	}
+
+	r := reqs[0]
+	for header, want := range map[string]string{
+		"Title":         "IAB Backup aborted",
+		"Priority":      "5",
+		"Tags":          "warning,iab",
+		"Markdown":      "yes",
+		"Authorization": "Bearer tk_secret",
+	} {
+		if got := r.Header.Get(header); got != want {
+			t.Errorf("%s=%q want %q", header, got, want)
+		}
+	}
+	if r.URL.Path != "/backups" || !strings.Contains(bodies[0], "copy c1 src->nas") {
+		t.Fatalf("unexpected request %s:\n%s", r.URL.Path, bodies[0])
+	}
+}
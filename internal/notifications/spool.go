@@ -0,0 +1,112 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// spoolMaxAge bounds how long an undelivered notification is retried.
+const spoolMaxAge = 7 * 24 * time.Hour
+
+// spoolEntry is an undelivered finish notification for one notifier.
+type spoolEntry struct {
+	Notifier string         `json:"notifier"`
+	Created  time.Time      `json:"created"`
+	Result   Result         `json:"result"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Hosts    []string       `json:"hosts,omitempty"`
+	Report   *runner.Report `json:"report,omitempty"`
+	Error    string         `json:"error,omitempty"`
+
+	path string
+}
+
+func newSpoolEntry(notifier string, s Summary, now time.Time) spoolEntry {
+	e := spoolEntry{
+		Notifier: notifier,
+		Created:  now,
+		Result:   s.Result,
+		Start:    s.Start,
+		End:      s.End,
+		Hosts:    s.Hosts,
+		Report:   s.Report,
+	}
+	if s.Err != nil {
+		e.Error = s.Err.Error()
+	}
+	return e
+}
+
+// summary restores the summary of the run, marked as delayed.
+func (e spoolEntry) summary() Summary {
+	s := Summary{Result: e.Result, Start: e.Start, End: e.End, Hosts: e.Hosts, Report: e.Report, Delayed: true}
+	if e.Error != "" {
+		s.Err = errors.New(e.Error)
+	}
+	return s
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// writeSpool stores e as a new file in dir.
+func writeSpool(dir string, e spoolEntry) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-*.json", e.Created.UTC().Format("20060102-150405"), unsafeFileChars.ReplaceAllString(e.Notifier, "_"))
+	f, err := os.CreateTemp(dir, name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// readSpool returns all entries in dir, oldest first. A missing dir is empty.
+// Unreadable files are returned as errors and left in place.
+func readSpool(dir string) ([]spoolEntry, []error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, []error{err}
+	}
+	sort.Strings(paths)
+
+	var entries []spoolEntry
+	var errs []error
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var e spoolEntry
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		e.path = path
+		entries = append(entries, e)
+	}
+	return entries, errs
+}
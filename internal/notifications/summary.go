@@ -0,0 +1,228 @@
+package notifications
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/history"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// maxListedTasks limits how many failed tasks a message lists.
+const maxListedTasks = 20
+
+// Summary describes a finished run for the notifiers.
+type Summary struct {
+	Result Result
+	Start  time.Time
+	End    time.Time
+	Hosts  []string       // names of all hosts of the run
+	Report *runner.Report // nil if the run failed before any task started
+	Err    error          // error of the run, nil on success
+
+	Delayed bool // sent from the spool after an earlier delivery failed
+}
+
+// Duration returns the run time, rounded to seconds.
+func (s Summary) Duration() time.Duration {
+	return s.End.Sub(s.Start).Round(time.Second)
+}
+
+// Counts returns the number of snapshots created, successful copies and pruned snapshots.
+func (s Summary) Counts() (created, copied, pruned int) {
+	if s.Report == nil {
+		return 0, 0, 0
+	}
+	for _, t := range s.Report.Tasks {
+		created += len(t.SnapshotsCreated)
+		pruned += len(t.SnapshotsPruned)
+		if t.Kind == runner.KindCopy && t.Status == runner.TaskSuccess {
+			copied++
+		}
+	}
+	return created, copied, pruned
+}
+
+// FailedTasks returns the reports of all failed tasks in plan order.
+func (s Summary) FailedTasks() []*runner.TaskReport {
+	if s.Report == nil {
+		return nil
+	}
+	var failed []*runner.TaskReport
+	for _, t := range s.Report.Tasks {
+		if t.Status == runner.TaskFailed {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// Title returns a one-line description of the outcome.
+func (s Summary) Title() string {
+	var title string
+	switch s.Result {
+	case ResultSuccess:
+		title = "IAB Backup succeeded"
+	case ResultAborted:
+		title = "IAB Backup aborted"
+	default:
+		title = "IAB Backup failed"
+	}
+	if s.Delayed {
+		title += " (delayed)"
+	}
+	return title
+}
+
+// Markdown renders the summary as a markdown message.
+func (s Summary) Markdown() string {
+	var b strings.Builder
+
+	switch s.Result {
+	case ResultSuccess:
+		b.WriteString("Backup run finished successfully")
+	case ResultAborted:
+		b.WriteString("Backup run was aborted before all tasks finished")
+	default:
+		b.WriteString("Backup run finished with errors")
+	}
+	fmt.Fprintf(&b, " after **%s**", s.Duration())
+	if len(s.Hosts) > 0 {
+		fmt.Fprintf(&b, " on %s", strings.Join(s.Hosts, ", "))
+	}
+	b.WriteString(".\n\n")
+	if s.Delayed {
+		fmt.Fprintf(&b, "_Delivered late, the run finished at %s._\n\n", s.End.Format(time.RFC1123))
+	}
+
+	if s.Report != nil {
+		created, copied, pruned := s.Counts()
+		copies := 0
+		for _, t := range s.Report.Tasks {
+			if t.Kind == runner.KindCopy {
+				copies++
+			}
+		}
+		fmt.Fprintf(&b, "- Snapshots created: %d\n", created)
+		fmt.Fprintf(&b, "- Copies: %d of %d\n", copied, copies)
+		fmt.Fprintf(&b, "- Snapshots pruned: %d", pruned)
+		if byRole := s.Report.PrunedByRole(); pruned > 0 {
+			var parts []string
+			for _, role := range slices.Sorted(maps.Keys(byRole)) {
+				parts = append(parts, fmt.Sprintf("%s %d", role, byRole[role]))
+			}
+			fmt.Fprintf(&b, " (%s)", strings.Join(parts, ", "))
+		}
+		b.WriteString("\n")
+		if s.Report.Retries > 0 {
+			fmt.Fprintf(&b, "- Retries: %d\n", s.Report.Retries)
+		}
+		if s.Report.Skipped > 0 {
+			fmt.Fprintf(&b, "- Skipped tasks: %d\n", s.Report.Skipped)
+		}
+	}
+
+	failed := s.FailedTasks()
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\n**Failed tasks (%d)**\n\n", len(failed))
+		for i, t := range failed {
+			if i == maxListedTasks {
+				fmt.Fprintf(&b, "- … and %d more\n", len(failed)-maxListedTasks)
+				break
+			}
+			fmt.Fprintf(&b, "- `%s`: %s\n", t.Name, t.Error)
+		}
+	} else if s.Err != nil {
+		fmt.Fprintf(&b, "\n**Error**\n\n%s\n", s.Err)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// Text renders the summary as plain text, listing failed tasks and the slowest tasks with their durations.
+func (s Summary) Text() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n", s.Title())
+	if s.Delayed {
+		fmt.Fprintf(&b, "Delivered late, the run finished at %s\n", s.End.Format(time.RFC1123))
+	}
+	fmt.Fprintf(&b, "Duration: %s\n", s.Duration())
+	if len(s.Hosts) > 0 {
+		fmt.Fprintf(&b, "Hosts: %s\n", strings.Join(s.Hosts, ", "))
+	}
+
+	if s.Report != nil {
+		r := s.Report
+		created, copied, pruned := s.Counts()
+		fmt.Fprintf(&b, "Tasks: %d total, %d failed, %d skipped, %d retries\n", r.Total, r.Failed, r.Skipped, r.Retries)
+		fmt.Fprintf(&b, "Snapshots created: %d, copies: %d, snapshots pruned: %d\n", created, copied, pruned)
+	}
+
+	failed := s.FailedTasks()
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\nFailed tasks:\n")
+		for i, t := range failed {
+			if i == maxListedTasks {
+				fmt.Fprintf(&b, "- ... and %d more\n", len(failed)-maxListedTasks)
+				break
+			}
+			fmt.Fprintf(&b, "- %s (%s, %d attempts): %s\n", t.Name, taskDuration(t), t.Attempts, t.Error)
+		}
+	} else if s.Err != nil {
+		fmt.Fprintf(&b, "\nError: %s\n", s.Err)
+	}
+
+	if s.Report != nil {
+		var ran []*runner.TaskReport
+		for _, t := range s.Report.Tasks {
+			if t.Status != runner.TaskSkipped {
+				ran = append(ran, t)
+			}
+		}
+		slices.SortStableFunc(ran, func(a, b *runner.TaskReport) int {
+			return cmp.Compare(b.DurationSeconds, a.DurationSeconds)
+		})
+		if len(ran) > 0 {
+			fmt.Fprintf(&b, "\nSlowest tasks:\n")
+			for _, t := range ran[:min(len(ran), 5)] {
+				fmt.Fprintf(&b, "- %s: %s\n", t.Name, taskDuration(t))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func taskDuration(t *runner.TaskReport) time.Duration {
+	return time.Duration(t.DurationSeconds * float64(time.Second)).Round(100 * time.Millisecond)
+}
+
+// ResourceOutcome is the status of one instance or volume in the run.
+type ResourceOutcome struct {
+	history.Resource
+	Duration time.Duration // sum of all its tasks
+}
+
+// Resources returns the outcome per instance and volume in plan order.
+func (s Summary) Resources() []ResourceOutcome {
+	if s.Report == nil {
+		return nil
+	}
+
+	durations := make(map[string]float64)
+	for _, t := range s.Report.Tasks {
+		durations[t.Project+"/"+t.Resource] += t.DurationSeconds
+	}
+
+	var out []ResourceOutcome
+	for _, res := range history.RunFromReport(s.Report).Resources {
+		d := time.Duration(durations[res.Project+"/"+res.Resource] * float64(time.Second))
+		out = append(out, ResourceOutcome{Resource: res, Duration: d.Round(100 * time.Millisecond)})
+	}
+	return out
+}
@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+// Webhook events.
+const (
+	EventStart      = "start"
+	EventFinish     = "finish"
+	EventTaskFailed = "taskFailed"
+)
+
+// defaultWebhookTemplate is used without a configured template.
+const defaultWebhookTemplate = `{
+  "event": {{json .Event}},
+  "title": {{json .Title}},
+  "message": {{json .Message}}
+  {{- with .Summary}},
+  "result": {{json .Result.String}},
+  "durationSeconds": {{.Duration.Seconds}}{{end}}
+  {{- with .Task}},
+  "task": {{json .}}{{end}}
+}`
+
+// WebhookData is passed to the payload template.
+//
+// Summary is set for finish, Task for taskFailed. Title and Message describe the event for every event.
+type WebhookData struct {
+	Event   string
+	Title   string
+	Message string // markdown
+	Hosts   []string
+	Time    time.Time
+	Summary *Summary
+	Task    *runner.TaskReport
+}
+
+var webhookFuncs = template.FuncMap{
+	// json encodes a value, e.g. to embed a message in a JSON payload: {"text": {{json .Message}}}
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// Webhook sends templated HTTP requests for run events, e.g. to Slack, Discord, Mattermost, Teams or Matrix hooks.
+type Webhook struct {
+	Client      *http.Client
+	Name        string
+	URL         string
+	Method      string
+	Headers     map[string]string
+	ContentType string
+	Events      []string
+	Hosts       []string
+	Template    *template.Template
+}
+
+type WebhookNotifier struct {
+	hook *Webhook
+}
+
+// NewWebhookNotifier parses the payload template of cfg. hosts are the names of all hosts of the run.
+func NewWebhookNotifier(cfg config.Webhook, hosts []string) (*WebhookNotifier, error) {
+	text := cfg.Template
+	if cfg.TemplateFile != "" {
+		b, err := os.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: read template: %w", cfg.Name, err)
+		}
+		text = string(b)
+	}
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	tmpl, err := template.New(cfg.Name).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("webhook %s: parse template: %w", cfg.Name, err)
+	}
+
+	return &WebhookNotifier{hook: &Webhook{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Name:        cfg.Name,
+		URL:         strings.TrimSpace(cfg.URL),
+		Method:      cfg.HTTPMethod(),
+		Headers:     cfg.Headers,
+		ContentType: cfg.PayloadContentType(),
+		Events:      cfg.EventList(),
+		Hosts:       hosts,
+		Template:    tmpl,
+	}}, nil
+}
+
+func (n WebhookNotifier) Name() string { return "webhook " + n.hook.Name }
+
+func (n WebhookNotifier) Start(ctx context.Context) error {
+	return n.hook.Send(ctx, WebhookData{
+		Event:   EventStart,
+		Title:   "IAB Backup started",
+		Message: "Backup run started on " + strings.Join(n.hook.Hosts, ", "),
+	})
+}
+
+func (n WebhookNotifier) Finish(ctx context.Context, summary Summary) error {
+	return n.hook.Send(ctx, WebhookData{
+		Event:   EventFinish,
+		Title:   summary.Title(),
+		Message: summary.Markdown(),
+		Summary: &summary,
+	})
+}
+
+// TaskFinished sends a taskFailed event for failed tasks.
+func (n WebhookNotifier) TaskFinished(ctx context.Context, task runner.TaskReport) error {
+	if task.Status != runner.TaskFailed {
+		return nil
+	}
+	return n.hook.Send(ctx, WebhookData{
+		Event:   EventTaskFailed,
+		Title:   "IAB task failed: " + task.Name,
+		Message: fmt.Sprintf("Task `%s` failed after %d attempts: %s", task.Name, task.Attempts, task.Error),
+		Task:    &task,
+	})
+}
+
+// Send renders the template for data and sends it, if the hook subscribed to the event.
+func (w *Webhook) Send(ctx context.Context, data WebhookData) error {
+	if w == nil || w.URL == "" || !slices.Contains(w.Events, data.Event) {
+		return nil
+	}
+	data.Hosts = w.Hosts
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+
+	var body bytes.Buffer
+	err := w.Template.Execute(&body, data)
+	if err != nil {
+		return fmt.Errorf("webhook %s: render template: %w", w.Name, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.Method, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.ContentType)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("webhook %s: unexpected status %s: %s", w.Name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rbnhln/incusAutobackup/internal/config"
+	"github.com/rbnhln/incusAutobackup/internal/runner"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	var reqs []*http.Request
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Errorf("invalid JSON payload %q: %v", b, err)
+		}
+		reqs, bodies = append(reqs, r), append(bodies, body)
+	}))
+	defer srv.Close()
+
+	n, err := NewWebhookNotifier(config.Webhook{
+		Name:    "slack",
+		URL:     srv.URL,
+		Method:  "put",
+		Headers: map[string]string{"X-Token": "secret"},
+		// a message with quotes and newlines must stay valid JSON
+		Template: `{"text": {{json (printf "*%s*\n%s" .Title .Message)}}, "hosts": {{json (join .Hosts ",")}}}`,
+		Events:   []string{"start", "taskFailed", "finish"},
+	}, []string{"src", "nas"})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, err := range []error{
+		n.Start(ctx),
+		n.TaskFinished(ctx, runner.TaskReport{Name: "prune c1", Status: runner.TaskSuccess}),
+		n.TaskFinished(ctx, runner.TaskReport{Name: "copy c1", Status: runner.TaskFailed, Attempts: 3, Error: `remote "nas" unreachable`}),
+		n.Finish(ctx, testSummary(ResultFailed)),
+	} {
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	// the successful task is not sent
+	if len(reqs) != 3 {
+		t.Fatalf("requests=%d want 3", len(reqs))
+	}
+	if reqs[0].Method != http.MethodPut || reqs[0].Header.Get("X-Token") != "secret" || reqs[0].Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected request: %s %v", reqs[0].Method, reqs[0].Header)
+	}
+	if bodies[0]["hosts"] != "src,nas" {
+		t.Fatalf("unexpected start payload: %v", bodies[0])
+	}
+	if want := "*IAB task failed: copy c1*\nTask `copy c1` failed after 3 attempts: remote \"nas\" unreachable"; bodies[1]["text"] != want {
+		t.Fatalf("unexpected task payload: %q", bodies[1]["text"])
+	}
+}
+
+func TestWebhookDefaultTemplate(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	n, err := NewWebhookNotifier(config.Webhook{Name: "generic", URL: srv.URL}, nil)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	// only finish is subscribed by default
+	if err := n.Start(context.Background()); err != nil || body != nil {
+		t.Fatalf("unexpected start event: %v %v", body, err)
+	}
+	if err := n.Finish(context.Background(), testSummary(ResultAborted)); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if body["event"] != "finish" || body["result"] != "aborted" || body["durationSeconds"] != 754.0 {
+		t.Fatalf("unexpected payload: %v", body)
+	}
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	_, err := NewWebhookNotifier(config.Webhook{Name: "broken", URL: "http://localhost", Template: "{{.Title"}, nil)
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+}
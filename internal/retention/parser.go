@@ -26,6 +26,11 @@ var (
 
 const IABSnapshotPrefix = "IAB_"
 
+// IABSnapshotName returns the name of an IAB snapshot taken at t.
+func IABSnapshotName(t time.Time) string {
+	return IABSnapshotPrefix + t.Format("20060102-150405")
+}
+
 func ParseIABSnapshotTime(name string) (time.Time, bool) {
 	if !strings.HasPrefix(name, IABSnapshotPrefix) {
 		return time.Time{}, false
@@ -0,0 +1,120 @@
+package runner
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Limits bounds how many tasks of a phase run at the same time.
+type Limits struct {
+	Workers int            // tasks running in parallel within a phase, values < 2 run sequentially
+	PerHost int            // tasks per host, 0 = unlimited
+	PerPool int            // tasks per storage pool, 0 = unlimited
+	Hosts   map[string]int // per host overrides of PerHost
+	Pools   map[string]int // per "host/pool" overrides of PerPool
+}
+
+// Resource is a host, or a storage pool on a host, occupied by a running task.
+type Resource struct {
+	Host string
+	Pool string
+}
+
+// resourceUser is implemented by tasks which are subject to per-host and per-pool limits.
+type resourceUser interface {
+	Resources() []Resource
+}
+
+func resourcesOf(t Task) []Resource {
+	ru, ok := t.(resourceUser)
+	if !ok {
+		return nil
+	}
+	return ru.Resources()
+}
+
+type limiter struct {
+	limits Limits
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+func newLimiter(l Limits) *limiter {
+	return &limiter{limits: l, sems: make(map[string]chan struct{})}
+}
+
+// acquire blocks until a slot for every resource is free.
+// Slots are taken in a fixed order so that tasks sharing resources cannot deadlock.
+func (l *limiter) acquire(ctx context.Context, res []Resource) (release func(), err error) {
+	var keys []string
+	for _, r := range res {
+		if r.Host == "" {
+			continue
+		}
+		keys = append(keys, "host:"+r.Host)
+		if r.Pool != "" {
+			keys = append(keys, "pool:"+r.Host+"/"+r.Pool)
+		}
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	var held []chan struct{}
+	release = func() {
+		for _, sem := range held {
+			<-sem
+		}
+	}
+
+	for _, key := range keys {
+		sem := l.semaphore(key)
+		if sem == nil {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+			held = append(held, sem)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// semaphore returns the semaphore for a resource key or nil if the resource is unlimited.
+func (l *limiter) semaphore(key string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if sem, ok := l.sems[key]; ok {
+		return sem
+	}
+
+	n := l.limitFor(key)
+	var sem chan struct{}
+	if n > 0 {
+		sem = make(chan struct{}, n)
+	}
+	l.sems[key] = sem
+	return sem
+}
+
+func (l *limiter) limitFor(key string) int {
+	if name, ok := strings.CutPrefix(key, "host:"); ok {
+		if n, ok := l.limits.Hosts[name]; ok {
+			return n
+		}
+		return l.limits.PerHost
+	}
+	if name, ok := strings.CutPrefix(key, "pool:"); ok {
+		if n, ok := l.limits.Pools[name]; ok {
+			return n
+		}
+		return l.limits.PerPool
+	}
+	return 0
+}
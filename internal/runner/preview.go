@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/retention"
+)
+
+// TaskPreview describes what a task would do, computed without changing anything.
+type TaskPreview struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	TaskInfo
+
+	// snapshot
+	Snapshot     string `json:"snapshot,omitempty"`
+	StopInstance bool   `json:"stopInstance,omitempty"`
+
+	// copy
+	Mode           string                       `json:"mode,omitempty"`
+	Refresh        bool                         `json:"refresh,omitempty"` // false: initial copy
+	TargetPool     string                       `json:"targetPool,omitempty"`
+	Devices        map[string]map[string]string `json:"devices,omitempty"`
+	DroppedDevices map[string]string            `json:"droppedDevices,omitempty"`
+
+	// project sync
+	Changes []string `json:"changes,omitempty"`
+
+	// prune
+	Policy    string   `json:"policy,omitempty"`
+	Keep      []string `json:"keep,omitempty"`
+	Remove    []string `json:"remove,omitempty"`
+	Unmanaged []string `json:"unmanaged,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type PhasePreview struct {
+	Name  string        `json:"name"`
+	Tasks []TaskPreview `json:"tasks"`
+}
+
+// PlanPreview is the outcome of Plan.Preview.
+type PlanPreview struct {
+	At     time.Time      `json:"at"`
+	Phases []PhasePreview `json:"phases"`
+}
+
+// previewer is implemented by tasks which can describe their effect in advance.
+type previewer interface {
+	Preview(x *ExecCtx, p *TaskPreview) error
+}
+
+// Preview computes the effect of every task in plan order without changing anything.
+// Results of earlier tasks are passed on through x.Snapshots like in Execute, so chained
+// copies and prunes see the snapshots a real run would create.
+func (p *Plan) Preview(x *ExecCtx) PlanPreview {
+	out := PlanPreview{At: time.Now()}
+	x.previewAt = out.At
+
+	for _, phase := range p.Phases {
+		pp := PhasePreview{Name: phase.Name}
+		for _, task := range phase.Tasks {
+			tp := TaskPreview{Name: task.Name(), Kind: task.Kind()}
+			if d, ok := task.(describer); ok {
+				tp.TaskInfo = d.Info()
+			}
+			if pv, ok := task.(previewer); ok {
+				err := pv.Preview(x, &tp)
+				if err != nil {
+					tp.Error = err.Error()
+				}
+			}
+			pp.Tasks = append(pp.Tasks, tp)
+		}
+		out.Phases = append(out.Phases, pp)
+	}
+	return out
+}
+
+func setPrunePreview(p *TaskPreview, policy string, plan retention.PrunePlan) {
+	p.Policy = policy
+	p.Keep = entryNames(plan.Keep)
+	p.Remove = entryNames(plan.Remove)
+	p.Unmanaged = plan.Unmanaged
+}
@@ -0,0 +1,171 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rbnhln/incusAutobackup/internal/retention"
+)
+
+type TaskStatus string
+
+const (
+	TaskSuccess TaskStatus = "success"
+	TaskFailed  TaskStatus = "failed"
+	TaskSkipped TaskStatus = "skipped" // not started because the run was aborted
+)
+
+// TaskInfo describes what a task works on.
+type TaskInfo struct {
+	Project  string `json:"project"`
+	Resource string `json:"resource"`         // e.g. "instance/c1" or "volume/local/v1"
+	Host     string `json:"host"`             // snapshotted or pruned host, target of a copy
+	Source   string `json:"source,omitempty"` // origin of a copy
+	Origin   string `json:"origin,omitempty"` // source host the project is backed up from
+	Role     string `json:"role,omitempty"`   // role of the pruned host
+}
+
+// describer is implemented by tasks which report what they work on.
+type describer interface {
+	Info() TaskInfo
+}
+
+// TaskReport is the outcome of a single task.
+type TaskReport struct {
+	Name string `json:"name"`
+	Kind Kind   `json:"kind"`
+	TaskInfo
+	Status             TaskStatus `json:"status"`
+	Start              time.Time  `json:"start,omitzero"`
+	End                time.Time  `json:"end,omitzero"`
+	DurationSeconds    float64    `json:"durationSeconds"`
+	Attempts           int        `json:"attempts,omitempty"`
+	BytesTransferred   int64      `json:"bytesTransferred,omitempty"`
+	SnapshotsCreated   []string   `json:"snapshotsCreated,omitempty"`
+	SnapshotsPruned    []string   `json:"snapshotsPruned,omitempty"`
+	SnapshotsKept      int        `json:"snapshotsKept,omitempty"`
+	SnapshotsUnmanaged int        `json:"snapshotsUnmanaged,omitempty"` // snapshots without IAB_ prefix, never pruned
+	Changes            []string   `json:"changes,omitempty"`            // objects created or updated by a project sync
+	Error              string     `json:"error,omitempty"`
+}
+
+// Report is the machine-readable summary of a run.
+type Report struct {
+	Start           time.Time     `json:"start"`
+	End             time.Time     `json:"end"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	Status          string        `json:"status"` // success, failed or aborted
+	Total           int           `json:"total"`
+	Failed          int           `json:"failed"`
+	Skipped         int           `json:"skipped"`
+	Retries         int           `json:"retries"`
+	Discovered      []Discovered  `json:"discovered,omitempty"`
+	Tasks           []*TaskReport `json:"tasks"`
+}
+
+// Discovered lists the resources the selectors of a project resolved to at run start.
+type Discovered struct {
+	Project   string   `json:"project"`
+	Instances []string `json:"instances,omitempty"`
+	Volumes   []string `json:"volumes,omitempty"` // <pool>/<name>
+}
+
+func newTaskReport(t Task) *TaskReport {
+	tr := &TaskReport{Name: t.Name(), Kind: t.Kind(), Status: TaskSkipped}
+	if d, ok := t.(describer); ok {
+		tr.TaskInfo = d.Info()
+	}
+	return tr
+}
+
+func (tr *TaskReport) finish(attempts int, err error) {
+	tr.End = time.Now()
+	tr.DurationSeconds = tr.End.Sub(tr.Start).Seconds()
+	tr.Attempts = attempts
+	tr.Status = TaskSuccess
+	if err != nil {
+		tr.Status = TaskFailed
+		tr.Error = err.Error()
+	}
+}
+
+// PrunedByRole returns the number of pruned snapshots per host role.
+func (r *Report) PrunedByRole() map[string]int {
+	pruned := make(map[string]int)
+	for _, tr := range r.Tasks {
+		if tr.Kind == KindPrune {
+			pruned[tr.Role] += len(tr.SnapshotsPruned)
+		}
+	}
+	return pruned
+}
+
+// WriteFile writes the report as indented JSON, replacing path atomically.
+func (r *Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// The record helpers are used by tasks to add details to their report entry.
+// They are no-ops when the task runs without a report (e.g. in tests).
+
+func (x *ExecCtx) recordSnapshot(name string) {
+	if x.report == nil || name == "" {
+		return
+	}
+	x.report.SnapshotsCreated = append(x.report.SnapshotsCreated, name)
+}
+
+func (x *ExecCtx) recordTransfer(bytes int64) {
+	if x.report == nil {
+		return
+	}
+	x.report.BytesTransferred += bytes
+}
+
+func (x *ExecCtx) recordChanges(changes []string) {
+	if x.report == nil {
+		return
+	}
+	x.report.Changes = append(x.report.Changes, changes...)
+}
+
+func entryNames(entries []retention.Entry) []string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func (x *ExecCtx) recordPrune(plan retention.PrunePlan) {
+	if x.report == nil {
+		return
+	}
+	x.report.SnapshotsPruned = append(x.report.SnapshotsPruned, entryNames(plan.Remove)...)
+	x.report.SnapshotsKept = len(plan.Keep)
+	x.report.SnapshotsUnmanaged = len(plan.Unmanaged)
+}
@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lxc/incus/v6/shared/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Kind groups tasks for timeouts and reporting.
+type Kind string
+
+const (
+	KindSnapshot Kind = "snapshot"
+	KindCopy     Kind = "copy"
+	KindPrune    Kind = "prune"
+)
+
+// ErrorClass categorizes task errors for the retry policy.
+type ErrorClass string
+
+const (
+	ErrorNetwork ErrorClass = "network" // connection reset/refused, TLS and transport errors, also of Incus operations
+	ErrorTimeout ErrorClass = "timeout" // the attempt exceeded its per-kind timeout
+	ErrorServer  ErrorClass = "server"  // api.StatusError 5xx
+	ErrorClient  ErrorClass = "client"  // api.StatusError 4xx
+	ErrorOther   ErrorClass = "other"   // everything else, e.g. Incus operations failing on the server
+	ErrorInvalid ErrorClass = "invalid" // the resource could not be planned, never retried
+)
+
+// DefaultRetryOn is used when RetryPolicy.RetryOn is empty.
+var DefaultRetryOn = []ErrorClass{ErrorNetwork, ErrorTimeout, ErrorServer}
+
+// RetryPolicy controls how often a failed task is attempted again.
+type RetryPolicy struct {
+	Attempts   int           // total attempts per task, values < 2 disable retries
+	Backoff    time.Duration // delay before the second attempt, doubled for each further attempt
+	MaxBackoff time.Duration // upper bound for the delay, 0 = unbounded
+	RetryOn    []ErrorClass  // retried error classes, empty = DefaultRetryOn
+}
+
+func (p RetryPolicy) retries(class ErrorClass) bool {
+	if class == ErrorInvalid {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return slices.Contains(DefaultRetryOn, class)
+	}
+	return slices.Contains(p.RetryOn, class)
+}
+
+// delay returns the backoff after the given (1-based) failed attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Classify returns the error class of a task error. Typed errors are checked first: the timeout of
+// the attempt, api.StatusError codes, net.Error, unexpected EOF and the errnos of broken connections.
+// Errors of Incus operations only carry the error text of the server, matching that text against
+// transientOperationErrors is the last resort before ErrorOther.
+func Classify(err error) ErrorClass {
+	var invalid invalidError
+	if errors.As(err, &invalid) {
+		return ErrorInvalid
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+
+	status, ok := api.StatusErrorMatch(err)
+	if ok {
+		switch {
+		case status >= http.StatusInternalServerError:
+			return ErrorServer
+		case status >= http.StatusBadRequest:
+			return ErrorClient
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return ErrorNetwork
+	}
+
+	// last resort: Incus operations only report the error text of the server, e.g. an interrupted migration
+	msg := strings.ToLower(err.Error())
+	if strings.HasSuffix(msg, ": eof") || slices.ContainsFunc(transientOperationErrors, func(s string) bool {
+		return strings.Contains(msg, s)
+	}) {
+		return ErrorNetwork
+	}
+
+	return ErrorOther
+}
+
+// transientOperationErrors are parts of Incus operation errors caused by a broken transfer.
+var transientOperationErrors = []string{
+	"connection reset by peer",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"i/o timeout",
+	"websocket: close",
+	"error transferring",
+}
+
+// runTask executes t according to the retry policy and returns the number of attempts made.
+// The resource slots of the task stay occupied during the backoff.
+func (x *ExecCtx) runTask(t Task) (int, error) {
+	attempts := max(x.Retry.Attempts, 1)
+
+	for attempt := 1; ; attempt++ {
+		err := x.runAttempt(t)
+		if err == nil || attempt >= attempts || x.Ctx.Err() != nil {
+			return attempt, err
+		}
+
+		class := Classify(err)
+		if !x.Retry.retries(class) {
+			return attempt, err
+		}
+		// the snapshot of a timed out attempt may still be created on the server,
+		// another attempt would leave a second snapshot behind
+		if class == ErrorTimeout && t.Kind() == KindSnapshot {
+			return attempt, err
+		}
+
+		delay := x.Retry.delay(attempt)
+		x.Logger.Warn("task attempt failed, retrying",
+			"task", t.Name(),
+			"attempt", attempt,
+			"attempts", attempts,
+			"class", class,
+			"retryIn", delay,
+			"error", err,
+		)
+		trace.SpanFromContext(x.Ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("iab.attempt", attempt),
+			attribute.String("iab.error_class", string(class)),
+			attribute.String("error", err.Error()),
+		))
+
+		select {
+		case <-time.After(delay):
+		case <-x.Ctx.Done():
+			return attempt, err
+		}
+	}
+}
+
+// runAttempt executes t once, bounded by the timeout configured for its kind.
+func (x *ExecCtx) runAttempt(t Task) error {
+	timeout := x.Timeouts[t.Kind()]
+	if timeout <= 0 {
+		return t.Execute(x)
+	}
+
+	ctx, cancel := context.WithTimeout(x.Ctx, timeout)
+	defer cancel()
+
+	attemptCtx := *x
+	attemptCtx.Ctx = ctx
+
+	err := t.Execute(&attemptCtx)
+	if err != nil && x.Ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s: %w", t.Kind(), timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// flakyTask fails with errs[i] on attempt i+1 and succeeds afterwards.
+type flakyTask struct {
+	errs  []error
+	calls *int
+}
+
+func (t flakyTask) Name() string { return "flaky" }
+func (t flakyTask) Kind() Kind   { return KindCopy }
+func (t flakyTask) Execute(x *ExecCtx) error {
+	i := *t.calls
+	*t.calls++
+	if i < len(t.errs) {
+		return t.errs[i]
+	}
+	return nil
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{fmt.Errorf("copy: %w", &net.OpError{Op: "read", Err: syscall.ECONNRESET}), ErrorNetwork},
+		{fmt.Errorf("copy: %w", syscall.ECONNREFUSED), ErrorNetwork},
+		{fmt.Errorf("copy: %w", context.DeadlineExceeded), ErrorTimeout},
+		{fmt.Errorf("get: %w", api.StatusErrorf(503, "unavailable")), ErrorServer},
+		{fmt.Errorf("get: %w", api.StatusErrorf(404, "not found")), ErrorClient},
+		{fmt.Errorf("read response: %w", io.EOF), ErrorNetwork},
+		{fmt.Errorf("copy: %w", &net.DNSError{Err: "i/o timeout", IsTimeout: true}), ErrorNetwork},
+		{errors.New("operation failed"), ErrorOther},
+		// as returned by op.Wait of an interrupted instance copy
+		{errors.New("Failed instance creation: Error transferring instance data: read tcp 10.0.0.2:8443->10.0.0.1:41822: read: connection reset by peer"), ErrorNetwork},
+		{errors.New("Failed storage volume creation: websocket: close 1006 (abnormal closure): unexpected EOF"), ErrorNetwork},
+		{errors.New("Failed instance migration: Error transferring instance data: EOF"), ErrorNetwork},
+		{errors.New("Failed instance creation: Failed creating instance record: Instance \"c1\" already exists"), ErrorOther},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v)=%s want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.delay(i + 1); got != w {
+			t.Errorf("delay(%d)=%s want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestRunTask_RetriesNetworkErrors(t *testing.T) {
+	calls := 0
+	task := flakyTask{errs: []error{syscall.ECONNRESET, syscall.ECONNRESET}, calls: &calls}
+
+	x := newTestExecCtx(Limits{})
+	x.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	attempts, err := x.runTask(task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts=%d want 3", attempts)
+	}
+}
+
+func TestRunTask_NoRetryOnClientError(t *testing.T) {
+	calls := 0
+	task := flakyTask{errs: []error{api.StatusErrorf(403, "forbidden")}, calls: &calls}
+
+	x := newTestExecCtx(Limits{})
+	x.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	attempts, err := x.runTask(task)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts=%d want 1", attempts)
+	}
+}
+
+// flakySnapshot is a flakyTask of kind snapshot.
+type flakySnapshot struct{ flakyTask }
+
+func (t flakySnapshot) Kind() Kind { return KindSnapshot }
+
+func TestRunTask_NoRetryOnSnapshotTimeout(t *testing.T) {
+	calls := 0
+	timedOut := fmt.Errorf("snapshot timed out after 1s: %w", context.DeadlineExceeded)
+	task := flakySnapshot{flakyTask{errs: []error{timedOut, syscall.ECONNRESET}, calls: &calls}}
+
+	x := newTestExecCtx(Limits{})
+	x.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	attempts, err := x.runTask(task)
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 1 {
+		t.Fatalf("attempts=%d err=%v, want a single timed out attempt", attempts, err)
+	}
+
+	// other errors of snapshots are still retried
+	calls = 1
+	attempts, err = x.runTask(task)
+	if err != nil || attempts != 2 {
+		t.Fatalf("attempts=%d err=%v, want success on the second attempt", attempts, err)
+	}
+}
+
+func TestRunTask_NoRetryOnInvalidTask(t *testing.T) {
+	// the discovery error of the project would be retried as a network error
+	task := InvalidTask{ProjectName: "default", Resource: "project", Err: fmt.Errorf("list volumes: %w", syscall.ECONNRESET)}
+
+	x := newTestExecCtx(Limits{})
+	x.Retry = RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryOn: []ErrorClass{ErrorNetwork, ErrorOther}}
+
+	attempts, err := x.runTask(task)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("err=%v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts=%d want 1", attempts)
+	}
+	if class := Classify(err); class != ErrorInvalid {
+		t.Fatalf("class=%s want %s", class, ErrorInvalid)
+	}
+}
+
+func TestRunAttempt_Timeout(t *testing.T) {
+	x := newTestExecCtx(Limits{})
+	x.Timeouts = map[Kind]time.Duration{KindCopy: time.Millisecond}
+
+	err := x.runAttempt(ctxTask{fakeTask{name: "slow"}})
+	if Classify(err) != ErrorTimeout {
+		t.Fatalf("err=%v want timeout", err)
+	}
+}
+
+// ctxTask blocks until its context is done.
+type ctxTask struct{ fakeTask }
+
+func (t ctxTask) Execute(x *ExecCtx) error {
+	<-x.Ctx.Done()
+	return x.Ctx.Err()
+}
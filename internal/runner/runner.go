@@ -5,58 +5,225 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	incus "github.com/lxc/incus/v6/client"
-	"github.com/lxc/incus/v6/shared/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// PhaseResult summarizes an executed phase for progress reporting.
+type PhaseResult struct {
+	Name     string
+	Tasks    int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+}
+
 type ExecCtx struct {
-	Ctx               context.Context
-	Logger            *slog.Logger
-	Source            incus.InstanceServer
-	Target            incus.InstanceServer
-	DryRunCopy        bool
-	DryRunPrune       bool
-	StopInstances     bool
-	VolumeSnapshots   map[string]*api.StorageVolume
-	InstanceSnapshots map[string]*api.Instance
+	Ctx           context.Context
+	Logger        *slog.Logger
+	Hosts         map[string]incus.InstanceServer
+	Unreachable   map[string]error // connect errors of hosts without a client, their tasks fail
+	DryRunCopy    bool
+	DryRunPrune   bool
+	StopInstances bool
+	Limits        Limits
+	Timeouts      map[Kind]time.Duration // per attempt, 0 = no timeout
+	Retry         RetryPolicy
+	Snapshots     *SnapshotStore
+	OnPhase       func(PhaseResult) // called after each executed phase, optional
+	OnTask        func(TaskReport)  // called after each finished task from its worker, optional
+
+	report    *TaskReport // entry of the running task, set per task by Plan.Execute
+	previewAt time.Time   // time of the snapshots a previewed run would create
+}
+
+// client returns the connection for a host taking part in the run.
+func (x *ExecCtx) client(hostName string) (incus.InstanceServer, error) {
+	if err := x.Unreachable[hostName]; err != nil {
+		return nil, fmt.Errorf("host %s unreachable: %w", hostName, err)
+	}
+	c, ok := x.Hosts[hostName]
+	if !ok || c == nil {
+		return nil, fmt.Errorf("no connection for host %q", hostName)
+	}
+	return c, nil
 }
 
 type Task interface {
 	Name() string
+	Kind() Kind
 	Execute(x *ExecCtx) error
 }
 
-type Plan struct {
+// Phase is a group of tasks which may run concurrently.
+// Phases are executed one after another, a phase starts when all tasks of the previous one are done.
+type Phase struct {
+	Name  string
 	Tasks []Task
 }
 
+type Plan struct {
+	Phases     []Phase
+	Discovered []Discovered // copied to the report
+}
+
+// BeginPhase starts a new phase, following calls to Add append to it.
+func (p *Plan) BeginPhase(name string) {
+	p.Phases = append(p.Phases, Phase{Name: name})
+}
+
 func (p *Plan) Add(t Task) {
-	p.Tasks = append(p.Tasks, t)
+	if len(p.Phases) == 0 {
+		p.BeginPhase("")
+	}
+	last := &p.Phases[len(p.Phases)-1]
+	last.Tasks = append(last.Tasks, t)
+}
+
+// Len returns the number of tasks in all phases.
+func (p *Plan) Len() int {
+	n := 0
+	for _, ph := range p.Phases {
+		n += len(ph.Tasks)
+	}
+	return n
 }
 
-func (p *Plan) Execute(x *ExecCtx) error {
-	total := len(p.Tasks)
-	failed := 0
-	var errs []error
+// Execute runs all phases and returns the report of the run. The error joins all task errors.
+func (p *Plan) Execute(x *ExecCtx) (*Report, error) {
+	total := p.Len()
+	lim := newLimiter(x.Limits)
+	report := &Report{Start: time.Now(), Total: total, Discovered: p.Discovered}
+
+	runCtx, runSpan := tracer.Start(x.Ctx, "run", trace.WithAttributes(attribute.Int("iab.tasks", total)))
+	defer endRunSpan(runSpan, report)
+
+	var (
+		started atomic.Int64
+		failed  atomic.Int64
+		skipped atomic.Int64
+		retries atomic.Int64
+		errs    []error
+	)
+
+	for _, phase := range p.Phases {
+		if len(phase.Tasks) == 0 {
+			continue
+		}
 
-	for i, task := range p.Tasks {
-		x.Logger.Info("executing task", "i", i+1, "n", total, "task", task.Name())
+		taskReports := make([]*TaskReport, len(phase.Tasks))
+		for i, task := range phase.Tasks {
+			taskReports[i] = newTaskReport(task)
+		}
+		report.Tasks = append(report.Tasks, taskReports...)
 
-		err := task.Execute(x)
-		if err != nil {
-			failed++
-			x.Logger.Error("task failed", "task", task.Name(), "error", err, "failed", failed, "total", total)
-			errs = append(errs, fmt.Errorf("task %q failed: %w", task.Name(), err))
+		if x.Ctx.Err() != nil {
+			skipped.Add(int64(len(phase.Tasks)))
 			continue
 		}
+
+		workers := min(max(x.Limits.Workers, 1), len(phase.Tasks))
+		x.Logger.Info("starting phase", "phase", phase.Name, "tasks", len(phase.Tasks), "workers", workers)
+
+		phaseStart := time.Now()
+		phaseCtx, phaseSpan := tracer.Start(runCtx, "phase "+phase.Name,
+			trace.WithAttributes(attribute.Int("iab.tasks", len(phase.Tasks)), attribute.Int("iab.workers", workers)))
+
+		phaseErrs := make([]error, len(phase.Tasks))
+		jobs := make(chan int)
+
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Go(func() {
+				for i := range jobs {
+					task := phase.Tasks[i]
+
+					// once the run is aborted, remaining tasks are skipped without counting as failed
+					if x.Ctx.Err() != nil {
+						skipped.Add(1)
+						continue
+					}
+
+					release, err := lim.acquire(x.Ctx, resourcesOf(task))
+					if err != nil {
+						skipped.Add(1)
+						continue
+					}
+
+					x.Logger.Info("executing task", "i", started.Add(1), "n", total, "task", task.Name())
+
+					tr := taskReports[i]
+					tr.Start = time.Now()
+					taskCtx, span := tracer.Start(phaseCtx, task.Name(), trace.WithAttributes(taskAttributes(tr)...))
+					tx := *x
+					tx.Ctx = taskCtx
+					tx.report = tr
+
+					attempts, err := tx.runTask(task)
+					release()
+					retries.Add(int64(attempts - 1))
+					tr.finish(attempts, err)
+					endTaskSpan(span, tr, err)
+					if x.OnTask != nil {
+						x.OnTask(*tr)
+					}
+
+					if err != nil {
+						n := failed.Add(1)
+						x.Logger.Error("task failed", "task", task.Name(), "error", err, "attempts", attempts, "failed", n, "total", total)
+						phaseErrs[i] = fmt.Errorf("task %q failed: %w", task.Name(), err)
+					}
+				}
+			})
+		}
+
+		for i := range phase.Tasks {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		phaseSpan.End()
+
+		pr := PhaseResult{Name: phase.Name, Tasks: len(phase.Tasks), Duration: time.Since(phaseStart)}
+		for i, err := range phaseErrs {
+			if err != nil {
+				errs = append(errs, err)
+				pr.Failed++
+			}
+			if taskReports[i].Status == TaskSkipped {
+				pr.Skipped++
+			}
+		}
+		if x.OnPhase != nil {
+			x.OnPhase(pr)
+		}
+	}
+
+	report.End = time.Now()
+	report.DurationSeconds = report.End.Sub(report.Start).Seconds()
+	report.Failed = int(failed.Load())
+	report.Skipped = int(skipped.Load())
+	report.Retries = int(retries.Load())
+
+	if err := x.Ctx.Err(); err != nil {
+		report.Status = "aborted"
+		x.Logger.Error("plan aborted", "failed", report.Failed, "skipped", report.Skipped, "retries", report.Retries, "total", total)
+		errs = append(errs, fmt.Errorf("run aborted, %d of %d tasks skipped: %w", report.Skipped, total, err))
+		return report, errors.Join(errs...)
 	}
 
-	if failed > 0 {
-		x.Logger.Error("plan finished with errors", "failed", failed, "total", total)
-		return errors.Join(errs...)
+	if report.Failed > 0 {
+		report.Status = "failed"
+		x.Logger.Error("plan finished with errors", "failed", report.Failed, "retries", report.Retries, "total", total)
+		return report, errors.Join(errs...)
 	}
 
-	x.Logger.Info("plan finished successfully", "total", total)
-	return nil
+	report.Status = "success"
+	x.Logger.Info("plan finished successfully", "retries", report.Retries, "total", total)
+	return report, nil
 }
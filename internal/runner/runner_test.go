@@ -0,0 +1,274 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fakeTask struct {
+	name string
+	res  []Resource
+	err  error
+	run  func()
+}
+
+func (t fakeTask) Name() string             { return t.name }
+func (t fakeTask) Kind() Kind               { return KindCopy }
+func (t fakeTask) Resources() []Resource    { return t.res }
+func (t fakeTask) Execute(x *ExecCtx) error { t.run(); return t.err }
+
+// gauge tracks the highest number of concurrently running tasks.
+type gauge struct {
+	cur  atomic.Int64
+	peak atomic.Int64
+}
+
+func (g *gauge) run() {
+	n := g.cur.Add(1)
+	for {
+		p := g.peak.Load()
+		if n <= p || g.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	g.cur.Add(-1)
+}
+
+func newTestExecCtx(l Limits) *ExecCtx {
+	return &ExecCtx{
+		Ctx:       context.Background(),
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Limits:    l,
+		Snapshots: NewSnapshotStore(),
+	}
+}
+
+func TestPlanExecute_PerHostLimit(t *testing.T) {
+	var g gauge
+	plan := Plan{}
+	plan.BeginPhase("copy")
+	for range 6 {
+		plan.Add(fakeTask{name: "t", res: []Resource{{Host: "nas"}}, run: g.run})
+	}
+
+	_, err := plan.Execute(newTestExecCtx(Limits{Workers: 6, PerHost: 2}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := g.peak.Load(); p != 2 {
+		t.Fatalf("peak=%d want 2", p)
+	}
+}
+
+func TestPlanExecute_PoolOverride(t *testing.T) {
+	var g gauge
+	plan := Plan{}
+	for range 4 {
+		plan.Add(fakeTask{name: "t", res: []Resource{{Host: "nas", Pool: "slow"}}, run: g.run})
+	}
+
+	_, err := plan.Execute(newTestExecCtx(Limits{Workers: 4, PerPool: 3, Pools: map[string]int{"nas/slow": 1}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := g.peak.Load(); p != 1 {
+		t.Fatalf("peak=%d want 1", p)
+	}
+}
+
+func TestPlanExecute_PhaseBarrier(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(s string) func() {
+		return func() {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			order = append(order, s)
+			mu.Unlock()
+		}
+	}
+
+	plan := Plan{}
+	plan.BeginPhase("snapshot")
+	plan.Add(fakeTask{name: "s1", run: record("snapshot")})
+	plan.Add(fakeTask{name: "s2", run: record("snapshot"), err: errors.New("boom")})
+	plan.BeginPhase("copy")
+	plan.Add(fakeTask{name: "c1", run: record("copy")})
+
+	_, err := plan.Execute(newTestExecCtx(Limits{Workers: 4}))
+	if err == nil {
+		t.Fatalf("expected error from failed task")
+	}
+	if len(order) != 3 || order[2] != "copy" {
+		t.Fatalf("copy ran before snapshot phase finished: %v", order)
+	}
+}
+
+func TestPlanExecute_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ran atomic.Int64
+	plan := Plan{}
+	plan.BeginPhase("snapshot")
+	plan.Add(fakeTask{name: "s1", run: func() { ran.Add(1); cancel() }})
+	plan.Add(fakeTask{name: "s2", run: func() { ran.Add(1) }})
+	plan.BeginPhase("copy")
+	plan.Add(fakeTask{name: "c1", run: func() { ran.Add(1) }})
+
+	x := newTestExecCtx(Limits{})
+	x.Ctx = ctx
+
+	_, err := plan.Execute(x)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err=%v want context.Canceled", err)
+	}
+	if n := ran.Load(); n != 1 {
+		t.Fatalf("ran=%d tasks after cancel, want 1", n)
+	}
+}
+
+func TestPlanExecute_Report(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	plan := Plan{}
+	plan.BeginPhase("snapshot")
+	plan.Add(fakeTask{name: "ok", run: func() {}})
+	plan.Add(fakeTask{name: "bad", run: func() {}, err: errors.New("boom")})
+	plan.BeginPhase("copy")
+	plan.Add(fakeTask{name: "aborting", run: cancel})
+	plan.BeginPhase("prune")
+	plan.Add(fakeTask{name: "never", run: func() {}})
+
+	x := newTestExecCtx(Limits{})
+	x.Ctx = ctx
+
+	report, _ := plan.Execute(x)
+	if report.Status != "aborted" || report.Failed != 1 || report.Skipped != 1 {
+		t.Fatalf("report status=%s failed=%d skipped=%d", report.Status, report.Failed, report.Skipped)
+	}
+
+	want := []TaskStatus{TaskSuccess, TaskFailed, TaskSuccess, TaskSkipped}
+	for i, tr := range report.Tasks {
+		if tr.Status != want[i] {
+			t.Errorf("task %s status=%s want %s", tr.Name, tr.Status, want[i])
+		}
+	}
+	if report.Tasks[1].Error != "boom" {
+		t.Errorf("error=%q want boom", report.Tasks[1].Error)
+	}
+}
+
+type previewTask struct {
+	fakeTask
+	err error
+}
+
+func (t previewTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	p.Snapshot = "IAB_" + t.name
+	return t.err
+}
+
+func TestPlanPreview(t *testing.T) {
+	executed := false
+	plan := Plan{}
+	plan.BeginPhase("snapshot")
+	plan.Add(previewTask{fakeTask: fakeTask{name: "a", run: func() { executed = true }}})
+	plan.BeginPhase("copy")
+	plan.Add(previewTask{fakeTask: fakeTask{name: "b"}, err: errors.New("target unreachable")})
+
+	pv := plan.Preview(newTestExecCtx(Limits{}))
+	if executed {
+		t.Fatalf("preview must not execute tasks")
+	}
+	if len(pv.Phases) != 2 || pv.Phases[0].Tasks[0].Snapshot != "IAB_a" {
+		t.Fatalf("unexpected preview: %+v", pv)
+	}
+	if pv.Phases[1].Tasks[0].Error != "target unreachable" {
+		t.Fatalf("error=%q want target unreachable", pv.Phases[1].Tasks[0].Error)
+	}
+}
+
+func TestPlanExecute_Spans(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		_ = tp.Shutdown(context.Background())
+	})
+
+	plan := Plan{}
+	plan.BeginPhase("copy")
+	plan.Add(fakeTask{name: "ok", run: func() {}})
+	plan.Add(fakeTask{name: "broken", err: errors.New("boom"), run: func() {}})
+
+	_, err := plan.Execute(newTestExecCtx(Limits{}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = s
+	}
+	run, phase, ok, broken := spans["run"], spans["phase copy"], spans["ok"], spans["broken"]
+	if run == nil || phase == nil || ok == nil || broken == nil {
+		t.Fatalf("missing spans: %v", spans)
+	}
+	if phase.Parent().SpanID() != run.SpanContext().SpanID() || broken.Parent().SpanID() != phase.SpanContext().SpanID() {
+		t.Fatal("unexpected span hierarchy")
+	}
+	if ok.Status().Code != codes.Unset || broken.Status().Code != codes.Error || run.Status().Code != codes.Error {
+		t.Fatalf("unexpected status: ok=%v broken=%v run=%v", ok.Status(), broken.Status(), run.Status())
+	}
+}
+
+func TestPlanExecute_OnPhase(t *testing.T) {
+	plan := Plan{}
+	plan.BeginPhase("snapshot")
+	plan.Add(fakeTask{name: "ok", run: func() {}})
+	plan.Add(fakeTask{name: "broken", err: errors.New("boom"), run: func() {}})
+	plan.BeginPhase("prune")
+	plan.Add(fakeTask{name: "ok", run: func() {}})
+
+	var phases []PhaseResult
+	x := newTestExecCtx(Limits{})
+	x.OnPhase = func(p PhaseResult) { phases = append(phases, p) }
+
+	_, _ = plan.Execute(x)
+	if len(phases) != 2 {
+		t.Fatalf("phases=%d want 2", len(phases))
+	}
+	if p := phases[0]; p.Name != "snapshot" || p.Tasks != 2 || p.Failed != 1 || p.Skipped != 0 {
+		t.Fatalf("unexpected phase result: %+v", p)
+	}
+}
+
+func TestExecCtxClient_Unreachable(t *testing.T) {
+	x := &ExecCtx{Unreachable: map[string]error{"nas": errors.New("connection refused")}}
+
+	_, err := x.client("nas")
+	if err == nil || err.Error() != "host nas unreachable: connection refused" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := x.client("prod"); err == nil {
+		t.Fatal("expected an error for a host without connection")
+	}
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
+
+// SnapshotStore holds the instances and volumes which were snapshotted or replicated
+// during a run, keyed by host, project and name. It is safe for concurrent use.
+type SnapshotStore struct {
+	mu        sync.RWMutex
+	volumes   map[string]*api.StorageVolume
+	instances map[string]*api.Instance
+}
+
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{
+		volumes:   make(map[string]*api.StorageVolume),
+		instances: make(map[string]*api.Instance),
+	}
+}
+
+func (s *SnapshotStore) PutVolume(key string, vol *api.StorageVolume) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.volumes[key] = vol
+}
+
+func (s *SnapshotStore) Volume(key string) (*api.StorageVolume, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	vol, ok := s.volumes[key]
+	return vol, ok
+}
+
+func (s *SnapshotStore) PutInstance(key string, inst *api.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[key] = inst
+}
+
+func (s *SnapshotStore) Instance(key string) (*api.Instance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inst, ok := s.instances[key]
+	return inst, ok
+}
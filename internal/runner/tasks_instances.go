@@ -1,36 +1,73 @@
 package runner
 
 import (
+	"cmp"
 	"fmt"
 	"time"
 
 	"github.com/rbnhln/incusAutobackup/internal/backup"
+	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
 type InstanceSnapshotTask struct {
 	ProjectName  string
 	InstanceName string
+	HostName     string
+	Stop         *bool // overrides ExecCtx.StopInstances for this instance
 }
 
+// stop reports whether the instance is stopped for the snapshot.
+func (t InstanceSnapshotTask) stop(x *ExecCtx) bool {
+	if t.Stop != nil {
+		return *t.Stop
+	}
+	return x.StopInstances
+}
+
+// InstanceCopyTask refreshes an instance from SourceName to TargetName.
+// SourceName is either the snapshotted source host or the target of a previous hop.
 type InstanceCopyTask struct {
 	ProjectName    string
 	InstanceName   string
+	SourceName     string
+	TargetName     string
+	OriginName     string // source host of the project, empty: SourceName
 	Mode           string
 	PoolName       string
+	RootPool       string // pool of the root disk on the target if known, for the limits; empty: PoolName
 	ExcludeDevices []string
+
+	// names on the hosts, empty: ProjectName and InstanceName
+	SourceProject  string
+	TargetProject  string
+	TargetInstance string
+
+	// renaming and pools of the root disk and attached custom volumes on the target, set on the first hop
+	VolumePrefix string
+	VolumeSuffix string
+	Pools        map[string]string // pool on the source -> pool on the target
+	VolumePools  map[string]string // "pool/volume" on the source -> pool on the target
 }
 
 type InstancePruneTask struct {
 	ProjectName  string
 	InstanceName string
-	SourcePolicy string
-	TargetPolicy string
+	Role         string
+	HostName     string
+	OriginName   string // source host of the project, empty: HostName
+	Policy       string
+
+	// names on HostName, empty: ProjectName and InstanceName
+	HostProject  string
+	HostInstance string
 }
 
 func (t InstanceSnapshotTask) Name() string {
 	return fmt.Sprintf("snapshot instance %s (%s)", t.InstanceName, t.ProjectName)
 }
 
+func (t InstanceSnapshotTask) Kind() Kind { return KindSnapshot }
+
 func (t InstanceSnapshotTask) Execute(x *ExecCtx) error {
 	logger := x.Logger.With("project", t.ProjectName, "instance", t.InstanceName)
 
@@ -39,56 +76,196 @@ func (t InstanceSnapshotTask) Execute(x *ExecCtx) error {
 		return nil
 	}
 
-	source := x.Source.UseProject(t.ProjectName)
+	sourceClient, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+	source := sourceClient.UseProject(t.ProjectName)
 
-	inst, err := backup.SnapshotInstance(logger, source, t.InstanceName, x.StopInstances)
+	inst, snapshot, err := backup.SnapshotInstance(x.Ctx, logger, source, t.InstanceName, t.stop(x))
 	if err != nil {
 		return err
 	}
+	x.recordSnapshot(snapshot)
 
-	key := instanceKey(t.ProjectName, t.InstanceName)
-	x.InstanceSnapshots[key] = inst
+	key := instanceKey(t.HostName, t.ProjectName, t.InstanceName)
+	x.Snapshots.PutInstance(key, inst)
 	return nil
 }
 
+func (t InstanceSnapshotTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: "instance/" + t.InstanceName, Host: t.HostName, Origin: t.HostName}
+}
+
+func (t InstanceSnapshotTask) Resources() []Resource {
+	return []Resource{{Host: t.HostName}}
+}
+
 func (t InstanceCopyTask) Name() string {
-	return fmt.Sprintf("copy instance %s (%s)", t.InstanceName, t.ProjectName)
+	return fmt.Sprintf("copy instance %s (%s) from %s to %s", t.InstanceName, t.ProjectName, t.SourceName, t.TargetName)
 }
 
+func (t InstanceCopyTask) Kind() Kind { return KindCopy }
+
 func (t InstanceCopyTask) Execute(x *ExecCtx) error {
-	logger := x.Logger.With("project", t.ProjectName, "instance", t.InstanceName)
+	logger := x.Logger.With("project", t.ProjectName, "instance", t.InstanceName, "from", t.SourceName, "target", t.TargetName)
 
 	if x.DryRunCopy {
 		logger.Info("dry-run: skipping copy")
 		return nil
 	}
 
-	key := instanceKey(t.ProjectName, t.InstanceName)
-	inst, ok := x.InstanceSnapshots[key]
+	// the snapshot task (first hop) or the previous hop stores the instance on success
+	key := instanceKey(t.SourceName, t.ProjectName, t.InstanceName)
+	inst, ok := x.Snapshots.Instance(key)
 	if !ok {
-		logger.Warn("skipping copy: no snapshot was created (snapshot may have failed)")
-		return fmt.Errorf("no snapshot result found for instance %s – snapshot phase likely failed", key)
+		logger.Warn("skipping copy: instance was not snapshotted or replicated to the upstream host")
+		return fmt.Errorf("no snapshot result found for instance %s – snapshot or upstream copy likely failed", key)
+	}
+
+	sourceClient, err := x.client(t.SourceName)
+	if err != nil {
+		return err
+	}
+	targetClient, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
+
+	source := sourceClient.UseProject(cmp.Or(t.SourceProject, t.ProjectName))
+	target := targetClient.UseProject(cmp.Or(t.TargetProject, t.ProjectName))
+
+	transferred, err := backup.CopyInstance(x.Ctx, logger, source, target, t.targetInstance(), t.Mode, t.PoolName, t.devices(), inst)
+	if err != nil {
+		return err
+	}
+	x.recordTransfer(transferred)
+
+	// the replica (incl. the IAB snapshot just transferred) is the origin for the next hop
+	replica, _, err := target.GetInstance(t.targetInstance())
+	if err != nil {
+		logger.Warn("cannot fetch replicated instance, further hops will skip it", "error", err)
+		return nil
+	}
+	x.Snapshots.PutInstance(instanceKey(t.TargetName, t.ProjectName, t.InstanceName), replica)
+	return nil
+}
+
+func (t InstanceCopyTask) targetInstance() string {
+	return cmp.Or(t.TargetInstance, t.InstanceName)
+}
+
+func (t InstanceCopyTask) devices() backup.DeviceOptions {
+	return backup.DeviceOptions{
+		Exclude:      t.ExcludeDevices,
+		VolumePrefix: t.VolumePrefix,
+		VolumeSuffix: t.VolumeSuffix,
+		Pools:        t.Pools,
+		VolumePools:  t.VolumePools,
 	}
+}
 
-	source := x.Source.UseProject(t.ProjectName)
-	target := x.Target.UseProject(t.ProjectName)
+func (t InstanceCopyTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: "instance/" + t.InstanceName, Host: t.TargetName, Source: t.SourceName, Origin: cmp.Or(t.OriginName, t.SourceName)}
+}
 
-	return backup.CopyInstance(logger, source, target, t.InstanceName, t.Mode, t.PoolName, t.ExcludeDevices, inst)
+func (t InstanceCopyTask) Resources() []Resource {
+	return []Resource{{Host: t.SourceName}, {Host: t.TargetName, Pool: cmp.Or(t.RootPool, t.PoolName)}}
 }
 
 func (t InstancePruneTask) Name() string {
-	return fmt.Sprintf("prune instance snapshot %s (%s)", t.InstanceName, t.ProjectName)
+	return fmt.Sprintf("prune instance snapshot %s (%s) on %s", t.InstanceName, t.ProjectName, t.HostName)
 }
 
+func (t InstancePruneTask) Kind() Kind { return KindPrune }
+
 func (t InstancePruneTask) Execute(x *ExecCtx) error {
-	logger := x.Logger.With("project", t.ProjectName, "instance", t.InstanceName)
+	logger := x.Logger.With("project", t.ProjectName, "instance", t.InstanceName, "host", t.HostName)
 
-	source := x.Source.UseProject(t.ProjectName)
-	target := x.Target.UseProject(t.ProjectName)
+	client, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+
+	plan, err := backup.PruneInstance(x.Ctx, logger, t.Role, client.UseProject(cmp.Or(t.HostProject, t.ProjectName)), cmp.Or(t.HostInstance, t.InstanceName), t.Policy, time.Now(), x.DryRunPrune)
+	if !x.DryRunPrune {
+		x.recordPrune(plan)
+	}
+	return err
+}
+
+func (t InstancePruneTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: "instance/" + t.InstanceName, Host: t.HostName, Origin: cmp.Or(t.OriginName, t.HostName), Role: t.Role}
+}
+
+func (t InstancePruneTask) Resources() []Resource {
+	return []Resource{{Host: t.HostName}}
+}
+
+func (t InstanceSnapshotTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	sourceClient, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+
+	inst, stop, err := backup.PreviewSnapshotInstance(sourceClient.UseProject(t.ProjectName), t.InstanceName, t.stop(x))
+	if err != nil {
+		return err
+	}
+	p.Snapshot = retention.IABSnapshotName(x.previewAt)
+	p.StopInstance = stop
+
+	x.Snapshots.PutInstance(instanceKey(t.HostName, t.ProjectName, t.InstanceName), inst)
+	return nil
+}
+
+func (t InstanceCopyTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	inst, ok := x.Snapshots.Instance(instanceKey(t.SourceName, t.ProjectName, t.InstanceName))
+	if !ok {
+		return fmt.Errorf("instance would not be snapshotted or replicated to %s", t.SourceName)
+	}
 
-	return backup.PruneInstance(logger, source, target, t.InstanceName, t.SourcePolicy, t.TargetPolicy, time.Now(), x.DryRunPrune)
+	targetClient, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
+
+	cp, err := backup.PreviewCopyInstance(targetClient.UseProject(cmp.Or(t.TargetProject, t.ProjectName)), t.targetInstance(), t.PoolName, t.devices(), inst)
+	if err != nil {
+		return err
+	}
+	p.Mode = t.Mode
+	p.Refresh = cp.Refresh
+	p.TargetPool = t.PoolName
+	p.Devices = cp.Devices
+	p.DroppedDevices = cp.Dropped
+
+	replica := *inst
+	replica.Name = t.targetInstance()
+	replica.Devices = cp.Devices
+	x.Snapshots.PutInstance(instanceKey(t.TargetName, t.ProjectName, t.InstanceName), &replica)
+	return nil
+}
+
+func (t InstancePruneTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	client, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	if _, ok := x.Snapshots.Instance(instanceKey(t.HostName, t.ProjectName, t.InstanceName)); ok {
+		pending = append(pending, retention.IABSnapshotName(x.previewAt))
+	}
+
+	plan, err := backup.PreviewPruneInstance(client.UseProject(cmp.Or(t.HostProject, t.ProjectName)), cmp.Or(t.HostInstance, t.InstanceName), t.Policy, x.previewAt, pending...)
+	if err != nil {
+		return err
+	}
+	setPrunePreview(p, t.Policy, plan)
+	return nil
 }
 
-func instanceKey(project, instance string) string {
-	return fmt.Sprintf("%s/%s", project, instance)
+func instanceKey(host, project, instance string) string {
+	return fmt.Sprintf("%s:%s/%s", host, project, instance)
 }
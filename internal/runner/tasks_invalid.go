@@ -0,0 +1,31 @@
+package runner
+
+import "fmt"
+
+// InvalidTask stands in for the tasks of a resource which could not be planned, e.g. because of
+// invalid user.iab.* keys. It fails with Err on the first attempt, so only this resource fails the run.
+type InvalidTask struct {
+	ProjectName string
+	Resource    string // as in TaskInfo, e.g. "instance/c1"
+	HostName    string
+	Err         error
+}
+
+func (t InvalidTask) Name() string {
+	return fmt.Sprintf("plan %s (%s)", t.Resource, t.ProjectName)
+}
+
+func (t InvalidTask) Kind() Kind { return KindSnapshot }
+
+func (t InvalidTask) Execute(x *ExecCtx) error { return invalidError{t.Err} }
+
+func (t InvalidTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: t.Resource, Host: t.HostName, Origin: t.HostName}
+}
+
+func (t InvalidTask) Preview(x *ExecCtx, p *TaskPreview) error { return t.Err }
+
+// invalidError marks the error of an InvalidTask as ErrorInvalid, whatever the planning error was.
+type invalidError struct{ error }
+
+func (e invalidError) Unwrap() error { return e.error }
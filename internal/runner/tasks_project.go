@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"cmp"
+	"fmt"
+
+	"github.com/rbnhln/incusAutobackup/internal/backup"
+)
+
+// ProjectSyncTask replicates a project with its profiles and, if requested, its networks and network
+// ACLs from SourceName to TargetName. It runs in a phase before the copies of the same hop.
+type ProjectSyncTask struct {
+	ProjectName string
+	SourceName  string
+	TargetName  string
+	OriginName  string // source host of the project, empty: SourceName
+	Networks    bool
+	NetworkACLs bool
+
+	// names on the hosts, empty: ProjectName
+	SourceProject string
+	TargetProject string
+
+	// renaming and pools of the disks in the profiles, as for InstanceCopyTask set on the first hop
+	VolumePrefix string
+	VolumeSuffix string
+	Pools        map[string]string
+	VolumePools  map[string]string
+}
+
+func (t ProjectSyncTask) objects() backup.ProjectObjects {
+	return backup.ProjectObjects{
+		Profiles:    true,
+		Networks:    t.Networks,
+		NetworkACLs: t.NetworkACLs,
+		Devices: backup.DeviceOptions{
+			VolumePrefix: t.VolumePrefix,
+			VolumeSuffix: t.VolumeSuffix,
+			Pools:        t.Pools,
+			VolumePools:  t.VolumePools,
+		},
+	}
+}
+
+func (t ProjectSyncTask) Name() string {
+	return fmt.Sprintf("sync project %s from %s to %s", t.ProjectName, t.SourceName, t.TargetName)
+}
+
+func (t ProjectSyncTask) Kind() Kind { return KindCopy }
+
+func (t ProjectSyncTask) Execute(x *ExecCtx) error {
+	logger := x.Logger.With("project", t.ProjectName, "from", t.SourceName, "target", t.TargetName)
+
+	if x.DryRunCopy {
+		logger.Info("dry-run: skipping project sync")
+		return nil
+	}
+
+	source, err := x.client(t.SourceName)
+	if err != nil {
+		return err
+	}
+	target, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
+
+	changes, err := backup.SyncProject(x.Ctx, logger, source, target, cmp.Or(t.SourceProject, t.ProjectName), cmp.Or(t.TargetProject, t.ProjectName), t.objects(), false)
+	x.recordChanges(changes)
+	return err
+}
+
+func (t ProjectSyncTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: "project/" + t.ProjectName, Host: t.TargetName, Source: t.SourceName, Origin: cmp.Or(t.OriginName, t.SourceName)}
+}
+
+func (t ProjectSyncTask) Resources() []Resource {
+	return []Resource{{Host: t.SourceName}, {Host: t.TargetName}}
+}
+
+func (t ProjectSyncTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	source, err := x.client(t.SourceName)
+	if err != nil {
+		return err
+	}
+	target, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
+
+	changes, err := backup.SyncProject(x.Ctx, x.Logger, source, target, cmp.Or(t.SourceProject, t.ProjectName), cmp.Or(t.TargetProject, t.ProjectName), t.objects(), true)
+	p.Changes = changes
+	return err
+}
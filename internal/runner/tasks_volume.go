@@ -1,37 +1,61 @@
 package runner
 
 import (
+	"cmp"
 	"fmt"
 	"time"
 
 	"github.com/rbnhln/incusAutobackup/internal/backup"
+	"github.com/rbnhln/incusAutobackup/internal/retention"
 )
 
 type VolumeSnapshotTask struct {
 	ProjectName string
 	PoolName    string
 	VolumeName  string
+	HostName    string
 }
 
+// VolumeCopyTask refreshes a custom volume from SourceName to TargetName.
+// SourceName is either the snapshotted source host or the target of a previous hop.
 type VolumeCopyTask struct {
 	ProjectName string
 	PoolName    string
 	VolumeName  string
+	SourceName  string
+	TargetName  string
+	OriginName  string // source host of the project, empty: SourceName
 	Mode        string
+
+	// names on the hosts, empty: ProjectName, PoolName and VolumeName
+	SourceProject string
+	TargetProject string
+	SourcePool    string
+	TargetPool    string
+	TargetVolume  string
 }
 
 type VolumePruneTask struct {
-	ProjectName  string
-	PoolName     string
-	VolumeName   string
-	SourcePolicy string
-	TargetPolicy string
+	ProjectName string
+	PoolName    string
+	VolumeName  string
+	Role        string
+	HostName    string
+	OriginName  string // source host of the project, empty: HostName
+	Policy      string
+
+	// names on HostName, empty: ProjectName, PoolName and VolumeName
+	HostProject string
+	HostPool    string
+	HostVolume  string
 }
 
 func (t VolumeSnapshotTask) Name() string {
 	return fmt.Sprintf("snapshot volume %s/%s (%s)", t.PoolName, t.VolumeName, t.ProjectName)
 }
 
+func (t VolumeSnapshotTask) Kind() Kind { return KindSnapshot }
+
 func (t VolumeSnapshotTask) Execute(x *ExecCtx) error {
 	logger := x.Logger.With("project", t.ProjectName, "pool", t.PoolName, "volume", t.VolumeName)
 
@@ -40,57 +64,193 @@ func (t VolumeSnapshotTask) Execute(x *ExecCtx) error {
 		return nil
 	}
 
-	source := x.Source.UseProject(t.ProjectName)
+	sourceClient, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+	source := sourceClient.UseProject(t.ProjectName)
 
-	vol, err := backup.SnapshotVolume(logger, source, t.PoolName, t.VolumeName)
+	vol, snapshot, err := backup.SnapshotVolume(x.Ctx, logger, source, t.PoolName, t.VolumeName)
 	if err != nil {
 		return err
 	}
+	x.recordSnapshot(snapshot)
 
-	key := volumeKey(t.ProjectName, t.PoolName, t.VolumeName)
-	x.VolumeSnapshots[key] = vol
+	key := volumeKey(t.HostName, t.ProjectName, t.PoolName, t.VolumeName)
+	x.Snapshots.PutVolume(key, vol)
 	return nil
 }
 
+func (t VolumeSnapshotTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: volumeResource(t.PoolName, t.VolumeName), Host: t.HostName, Origin: t.HostName}
+}
+
+func (t VolumeSnapshotTask) Resources() []Resource {
+	return []Resource{{Host: t.HostName, Pool: t.PoolName}}
+}
+
 func (t VolumeCopyTask) Name() string {
-	return fmt.Sprintf("copy volume %s/%s (%s)", t.PoolName, t.VolumeName, t.ProjectName)
+	return fmt.Sprintf("copy volume %s/%s (%s) from %s to %s", t.PoolName, t.VolumeName, t.ProjectName, t.SourceName, t.TargetName)
 }
 
+func (t VolumeCopyTask) Kind() Kind { return KindCopy }
+
 func (t VolumeCopyTask) Execute(x *ExecCtx) error {
-	logger := x.Logger.With("project", t.ProjectName, "pool", t.PoolName, "volume", t.VolumeName)
+	logger := x.Logger.With("project", t.ProjectName, "pool", t.PoolName, "volume", t.VolumeName, "from", t.SourceName, "target", t.TargetName)
 
 	if x.DryRunCopy {
 		logger.Info("dry-run: skipping copy")
 		return nil
 	}
 
-	key := volumeKey(t.ProjectName, t.PoolName, t.VolumeName)
-	vol, ok := x.VolumeSnapshots[key]
+	// the snapshot task (first hop) or the previous hop stores the volume on success
+	key := volumeKey(t.SourceName, t.ProjectName, t.PoolName, t.VolumeName)
+	vol, ok := x.Snapshots.Volume(key)
 	if !ok {
-		logger.Warn("skipping copy: no snapshot was created (snapshot may have failed)")
-		return fmt.Errorf("no snapshot result found for volume %s – snapshot phase likely failed", key)
+		logger.Warn("skipping copy: volume was not snapshotted or replicated to the upstream host")
+		return fmt.Errorf("no snapshot result found for volume %s – snapshot or upstream copy likely failed", key)
 	}
 
-	source := x.Source.UseProject(t.ProjectName)
-	target := x.Target.UseProject(t.ProjectName)
+	sourceClient, err := x.client(t.SourceName)
+	if err != nil {
+		return err
+	}
+	targetClient, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
 
-	return backup.CopyVolume(logger, source, target, t.PoolName, t.VolumeName, t.Mode, vol)
+	source := sourceClient.UseProject(cmp.Or(t.SourceProject, t.ProjectName))
+	target := targetClient.UseProject(cmp.Or(t.TargetProject, t.ProjectName))
+
+	transferred, err := backup.CopyVolume(x.Ctx, logger, source, target, t.sourcePool(), t.targetPool(), t.targetVolume(), t.Mode, vol)
+	if err != nil {
+		return err
+	}
+	x.recordTransfer(transferred)
+
+	// the replica (incl. the IAB snapshot just transferred) is the origin for the next hop
+	replica, _, err := target.GetStoragePoolVolume(t.targetPool(), "custom", t.targetVolume())
+	if err != nil {
+		logger.Warn("cannot fetch replicated volume, further hops will skip it", "error", err)
+		return nil
+	}
+	x.Snapshots.PutVolume(volumeKey(t.TargetName, t.ProjectName, t.PoolName, t.VolumeName), replica)
+	return nil
+}
+
+func (t VolumeCopyTask) sourcePool() string {
+	return cmp.Or(t.SourcePool, t.PoolName)
+}
+
+func (t VolumeCopyTask) targetPool() string {
+	return cmp.Or(t.TargetPool, t.PoolName)
+}
+
+func (t VolumeCopyTask) targetVolume() string {
+	return cmp.Or(t.TargetVolume, t.VolumeName)
+}
+
+func (t VolumeCopyTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: volumeResource(t.PoolName, t.VolumeName), Host: t.TargetName, Source: t.SourceName, Origin: cmp.Or(t.OriginName, t.SourceName)}
+}
+
+func (t VolumeCopyTask) Resources() []Resource {
+	return []Resource{{Host: t.SourceName, Pool: t.sourcePool()}, {Host: t.TargetName, Pool: t.targetPool()}}
 }
 
 func (t VolumePruneTask) Name() string {
-	return fmt.Sprintf("prune volume snapshots %s/%s (%s)", t.PoolName, t.VolumeName, t.ProjectName)
+	return fmt.Sprintf("prune volume snapshots %s/%s (%s) on %s", t.PoolName, t.VolumeName, t.ProjectName, t.HostName)
 }
 
+func (t VolumePruneTask) Kind() Kind { return KindPrune }
+
 func (t VolumePruneTask) Execute(x *ExecCtx) error {
-	logger := x.Logger.With("project", t.ProjectName, "pool", t.PoolName, "volume", t.VolumeName)
+	logger := x.Logger.With("project", t.ProjectName, "pool", t.PoolName, "volume", t.VolumeName, "host", t.HostName)
 
-	source := x.Source.UseProject(t.ProjectName)
-	target := x.Target.UseProject(t.ProjectName)
+	client, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
 
 	now := time.Now()
-	return backup.PruneVolume(logger, source, target, t.PoolName, t.VolumeName, t.SourcePolicy, t.TargetPolicy, now, x.DryRunPrune)
+	plan, err := backup.PruneVolume(x.Ctx, logger, t.Role, client.UseProject(cmp.Or(t.HostProject, t.ProjectName)), cmp.Or(t.HostPool, t.PoolName), cmp.Or(t.HostVolume, t.VolumeName), t.Policy, now, x.DryRunPrune)
+	if !x.DryRunPrune {
+		x.recordPrune(plan)
+	}
+	return err
+}
+
+func (t VolumePruneTask) Info() TaskInfo {
+	return TaskInfo{Project: t.ProjectName, Resource: volumeResource(t.PoolName, t.VolumeName), Host: t.HostName, Origin: cmp.Or(t.OriginName, t.HostName), Role: t.Role}
+}
+
+func (t VolumePruneTask) Resources() []Resource {
+	return []Resource{{Host: t.HostName, Pool: cmp.Or(t.HostPool, t.PoolName)}}
+}
+
+func (t VolumeSnapshotTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	sourceClient, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+
+	vol, err := backup.PreviewSnapshotVolume(sourceClient.UseProject(t.ProjectName), t.PoolName, t.VolumeName)
+	if err != nil {
+		return err
+	}
+	p.Snapshot = retention.IABSnapshotName(x.previewAt)
+
+	x.Snapshots.PutVolume(volumeKey(t.HostName, t.ProjectName, t.PoolName, t.VolumeName), vol)
+	return nil
+}
+
+func (t VolumeCopyTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	vol, ok := x.Snapshots.Volume(volumeKey(t.SourceName, t.ProjectName, t.PoolName, t.VolumeName))
+	if !ok {
+		return fmt.Errorf("volume would not be snapshotted or replicated to %s", t.SourceName)
+	}
+
+	targetClient, err := x.client(t.TargetName)
+	if err != nil {
+		return err
+	}
+
+	cp, err := backup.PreviewCopyVolume(targetClient.UseProject(cmp.Or(t.TargetProject, t.ProjectName)), t.targetPool(), t.targetVolume())
+	if err != nil {
+		return err
+	}
+	p.Mode = t.Mode
+	p.Refresh = cp.Refresh
+	p.TargetPool = t.targetPool()
+
+	x.Snapshots.PutVolume(volumeKey(t.TargetName, t.ProjectName, t.PoolName, t.VolumeName), vol)
+	return nil
+}
+
+func (t VolumePruneTask) Preview(x *ExecCtx, p *TaskPreview) error {
+	client, err := x.client(t.HostName)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	if _, ok := x.Snapshots.Volume(volumeKey(t.HostName, t.ProjectName, t.PoolName, t.VolumeName)); ok {
+		pending = append(pending, retention.IABSnapshotName(x.previewAt))
+	}
+
+	plan, err := backup.PreviewPruneVolume(client.UseProject(cmp.Or(t.HostProject, t.ProjectName)), cmp.Or(t.HostPool, t.PoolName), cmp.Or(t.HostVolume, t.VolumeName), t.Policy, x.previewAt, pending...)
+	if err != nil {
+		return err
+	}
+	setPrunePreview(p, t.Policy, plan)
+	return nil
+}
+
+func volumeResource(pool, volume string) string {
+	return fmt.Sprintf("volume/%s/%s", pool, volume)
 }
 
-func volumeKey(project, pool, volume string) string {
-	return fmt.Sprintf("%s/%s/%s", project, pool, volume)
+func volumeKey(host, project, pool, volume string) string {
+	return fmt.Sprintf("%s:%s/%s/%s", host, project, pool, volume)
 }
@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans go to the global tracer provider, which does nothing unless tracing is configured.
+var tracer = otel.Tracer("github.com/rbnhln/incusAutobackup/internal/runner")
+
+func taskAttributes(tr *TaskReport) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("iab.kind", string(tr.Kind)),
+		attribute.String("iab.project", tr.Project),
+		attribute.String("iab.resource", tr.Resource),
+		attribute.String("iab.host", tr.Host),
+	}
+	if tr.Source != "" {
+		attrs = append(attrs, attribute.String("iab.source", tr.Source))
+	}
+	if tr.Role != "" {
+		attrs = append(attrs, attribute.String("iab.role", tr.Role))
+	}
+	return attrs
+}
+
+// endTaskSpan adds the outcome of the task and ends its span.
+func endTaskSpan(span trace.Span, tr *TaskReport, err error) {
+	span.SetAttributes(attribute.Int("iab.attempts", tr.Attempts))
+	if tr.BytesTransferred > 0 {
+		span.SetAttributes(attribute.Int64("iab.bytes_transferred", tr.BytesTransferred))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endRunSpan adds the summary of the run and ends its span.
+func endRunSpan(span trace.Span, report *Report) {
+	span.SetAttributes(
+		attribute.String("iab.status", report.Status),
+		attribute.Int("iab.tasks.failed", report.Failed),
+		attribute.Int("iab.tasks.skipped", report.Skipped),
+		attribute.Int("iab.retries", report.Retries),
+	)
+	if report.Status != "success" {
+		span.SetStatus(codes.Error, "run "+report.Status)
+	}
+	span.End()
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupExportsSpans(t *testing.T) {
+	var requests atomic.Int64
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	shutdown, err := Setup(context.Background(), srv.URL, map[string]string{"Authorization": "Bearer secret"}, "v1.2.3", "uuid")
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "run")
+	span.End()
+
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if requests.Load() == 0 || path != "/v1/traces" || auth != "Bearer secret" {
+		t.Fatalf("unexpected export: requests=%d path=%q auth=%q", requests.Load(), path, auth)
+	}
+}